@@ -5,12 +5,13 @@ go 1.23.2
 require (
 	github.com/dghubble/go-twitter v0.0.0-20221104224141-912508c3888b
 	github.com/dghubble/oauth1 v0.7.2
+	github.com/g8rswimmer/go-twitter/v2 v2.1.5
+	golang.org/x/crypto v0.36.0
+	golang.org/x/oauth2 v0.28.0
 )
 
 require (
 	github.com/cenkalti/backoff/v4 v4.1.3 // indirect
 	github.com/dghubble/sling v1.4.0 // indirect
-	github.com/g8rswimmer/go-twitter/v2 v2.1.5 // indirect
 	github.com/google/go-querystring v1.1.0 // indirect
-	golang.org/x/oauth2 v0.28.0 // indirect
 )
@@ -0,0 +1,70 @@
+// Package apierr defines the errors shared by the X, Dynalist and ntfy clients, so that
+// callers can tell failures apart with errors.Is and errors.As whichever service failed.
+package apierr
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var (
+	// ErrUnauthorized is returned when a service rejects the credentials of a request.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrForbidden is returned when the credentials are valid but lack access.
+	ErrForbidden = errors.New("forbidden")
+	// ErrRateLimited is returned when a request exceeded a rate limit. Where the service
+	// reports when the limit resets, the error is a *RateLimitError.
+	ErrRateLimited = errors.New("rate limited")
+	// ErrNotFound is returned when the requested object does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrTransient is returned for network failures and server errors, which are worth
+	// retrying later.
+	ErrTransient = errors.New("temporary failure")
+	// ErrInvalidToken is returned when a token is invalid, expired or revoked, and the
+	// user has to provide a new one.
+	ErrInvalidToken = errors.New("invalid token")
+)
+
+// RateLimitError is returned when the rate limit of an endpoint is exhausted.
+type RateLimitError struct {
+	Endpoint string
+	// Reset is when the endpoint accepts requests again.
+	Reset time.Time
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limit exceeded on %s until %s", e.Endpoint, e.Reset.Format(time.RFC3339))
+}
+
+// Is reports whether target is ErrRateLimited.
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// FromStatus wraps err, the failure of a request answered with the HTTP status code, in
+// the error of this package matching the status. err is returned as is if none does.
+func FromStatus(statusCode int, err error) error {
+	var kind error
+	switch {
+	case statusCode == http.StatusUnauthorized:
+		kind = ErrUnauthorized
+	case statusCode == http.StatusForbidden:
+		kind = ErrForbidden
+	case statusCode == http.StatusNotFound:
+		kind = ErrNotFound
+	case statusCode == http.StatusTooManyRequests:
+		kind = ErrRateLimited
+	case statusCode == http.StatusRequestTimeout || statusCode >= 500:
+		kind = ErrTransient
+	default:
+		return err
+	}
+	return fmt.Errorf("%w: %w", kind, err)
+}
+
+// Transient wraps err, a failure to send a request or read its response, in ErrTransient.
+func Transient(err error) error {
+	return fmt.Errorf("%w: %w", ErrTransient, err)
+}
@@ -0,0 +1,51 @@
+package apierr
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestFromStatus(t *testing.T) {
+	cause := errors.New("request failed")
+	tests := []struct {
+		statusCode int
+		want       error
+	}{
+		{http.StatusUnauthorized, ErrUnauthorized},
+		{http.StatusForbidden, ErrForbidden},
+		{http.StatusNotFound, ErrNotFound},
+		{http.StatusTooManyRequests, ErrRateLimited},
+		{http.StatusRequestTimeout, ErrTransient},
+		{http.StatusBadGateway, ErrTransient},
+	}
+	for _, tt := range tests {
+		err := FromStatus(tt.statusCode, cause)
+		if !errors.Is(err, tt.want) || !errors.Is(err, cause) {
+			t.Errorf("FromStatus(%d) = %v, expected it to wrap %v and the cause", tt.statusCode, err, tt.want)
+		}
+	}
+
+	if err := FromStatus(http.StatusBadRequest, cause); err != cause {
+		t.Errorf("Expected the cause for a 400, got %v", err)
+	}
+}
+
+func TestRateLimitError(t *testing.T) {
+	var err error = &RateLimitError{Endpoint: "/2/users/:id/bookmarks", Reset: time.Now()}
+	if !errors.Is(err, ErrRateLimited) {
+		t.Error("Expected a RateLimitError to be ErrRateLimited")
+	}
+	if errors.Is(err, ErrTransient) {
+		t.Error("Expected a RateLimitError not to be ErrTransient")
+	}
+}
+
+func TestTransient(t *testing.T) {
+	cause := errors.New("connection refused")
+	err := Transient(cause)
+	if !errors.Is(err, ErrTransient) || !errors.Is(err, cause) {
+		t.Errorf("Expected %v to wrap ErrTransient and the cause", err)
+	}
+}
@@ -3,26 +3,47 @@ package app
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
 	"net/http"
-	
+	"net/url"
 	"os"
 	"os/signal"
+	"sort"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/korjavin/tw2dynalist/internal/apierr"
 	"github.com/korjavin/tw2dynalist/internal/config"
 	"github.com/korjavin/tw2dynalist/internal/dynalist"
 	"github.com/korjavin/tw2dynalist/internal/logger"
 	"github.com/korjavin/tw2dynalist/internal/ntfy"
 	"github.com/korjavin/tw2dynalist/internal/scheduler"
+	"github.com/korjavin/tw2dynalist/internal/source"
 	"github.com/korjavin/tw2dynalist/internal/storage"
 	"github.com/korjavin/tw2dynalist/internal/twitter"
 )
 
+// statusNeedsAuthorization is shown on the dashboard until the user authorizes the app.
+const statusNeedsAuthorization = "Needs authorization"
+
 // App holds the application's dependencies.
 type App struct {
+	Config *config.Config
+	Logger *logger.Logger
+	Mux    *http.ServeMux
+	// Accounts are processed independently of each other, each with its own clients,
+	// schedules and metrics.
+	Accounts []*Account
+}
+
+// Account holds the dependencies of a single Twitter account.
+type Account struct {
+	// Name is empty when ACCOUNTS is not configured.
+	Name      string
 	Config    *config.Config
 	Logger    *logger.Logger
 	Storage   storage.Storage
@@ -30,8 +51,25 @@ type App struct {
 	Twitter   twitter.Client
 	Scheduler scheduler.Scheduler
 	Metrics   *Metrics
-	Mux       *http.ServeMux
 	Ntfy      ntfy.Client
+	// Sources are run on the main schedule, in order.
+	Sources []source.Source
+	// SourceSchedulers run the List and search sources, each on its own interval.
+	SourceSchedulers []scheduler.Scheduler
+
+	// runMu serializes runs of the bookmark and source pipelines, which share the clients.
+	runMu sync.Mutex
+	// cleanupOnce makes sure the processed bookmarks cleanup runs once, as soon as the
+	// Twitter account is authorized.
+	cleanupOnce sync.Once
+
+	// scopeMu guards missingScopes, the OAuth scopes whose features are turned off.
+	scopeMu       sync.Mutex
+	missingScopes map[string]bool
+
+	// lastAlert is the error last sent as an ntfy alert, so that a failure repeating on
+	// every run is only reported once. runMu must be held.
+	lastAlert string
 }
 
 // New creates a new App.
@@ -44,44 +82,141 @@ func New() (*App, error) {
 	log := logger.New(cfg.LogLevel)
 	log.Info("Log level set to: %s", cfg.LogLevel)
 
-	store, err := storage.NewFileStorage(cfg.CacheFilePath, log)
+	mux := http.NewServeMux()
+	router := twitter.NewRouter(mux)
+	app := &App{
+		Config: cfg,
+		Logger: log,
+		Mux:    mux,
+	}
+	for _, account := range cfg.Accounts {
+		a, err := newAccount(cfg.ForAccount(account), log, router)
+		if err != nil {
+			return nil, err
+		}
+		app.Accounts = append(app.Accounts, a)
+	}
+	return app, nil
+}
+
+// newAccount creates the clients and schedules of the account cfg belongs to.
+func newAccount(cfg *config.Config, log *logger.Logger, router *twitter.Router) (*Account, error) {
+	name := cfg.AccountName
+	if name != "" {
+		log = log.WithPrefix("[" + name + "] ")
+		log.Info("Setting up account")
+	}
+
+	store, err := openStorage(cfg.CacheFilePath, log)
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize storage: %v", err)
+		return nil, err
 	}
 
-	dynalistClient := dynalist.NewClient(cfg.DynalistToken, log)
-	mux := http.NewServeMux()
+	dynalistClient := dynalist.NewClient(cfg.DynalistAPIURL, cfg.DynalistToken, log)
 
-	twitterClient, err := twitter.NewClient(cfg, log, mux)
+	twitterClient, err := twitter.NewClient(cfg, log, router)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize Twitter client: %v", err)
 	}
 
 	ntfyClient := ntfy.NewClient(cfg.NtfyServer, cfg.NtfyTopic, cfg.NtfyUsername, cfg.NtfyPassword, log)
+	metrics := NewMetrics(cfg.CheckInterval)
+	twitterClient.SetRecorder(metrics)
 
-	app := &App{
+	a := &Account{
+		Name:     name,
 		Config:   cfg,
 		Logger:   log,
 		Storage:  store,
 		Dynalist: dynalistClient,
 		Twitter:  twitterClient,
-		Metrics:  NewMetrics(cfg.CheckInterval),
-		Mux:      mux,
+		Metrics:  metrics,
 		Ntfy:     ntfyClient,
+
+		missingScopes: make(map[string]bool),
 	}
 
-	app.Scheduler = scheduler.NewSimpleScheduler(cfg.CheckInterval, app.processBookmarks, log)
+	folders := make([]string, 0, len(cfg.FolderDestinations))
+	for name := range cfg.FolderDestinations {
+		folders = append(folders, name)
+	}
+	sort.Strings(folders)
+	a.Sources = append(a.Sources, twitter.NewBookmarkSource(twitterClient, folders, func() bool {
+		return cfg.RemoveBookmarks && a.hasScope(twitter.ScopeBookmarkWrite)
+	}, log))
+	if cfg.SyncLikes {
+		a.Sources = append(a.Sources, twitter.NewLikeSource(twitterClient))
+	}
 
-	return app, nil
+	a.Scheduler = scheduler.NewSimpleScheduler(cfg.CheckInterval, a.processBookmarks, log)
+	for _, sourceConfig := range cfg.Sources {
+		src := twitter.NewTimelineSource(twitterClient, sourceConfig, log)
+		var sourceScheduler *scheduler.SimpleScheduler
+		sourceScheduler = scheduler.NewSimpleScheduler(sourceConfig.Interval, func() {
+			a.processSource(src, sourceScheduler)
+		}, log)
+		a.SourceSchedulers = append(a.SourceSchedulers, sourceScheduler)
+	}
+
+	return a, nil
+}
+
+// openStorage opens the cache file, moving bookmarks processed before sources had their
+// own namespaces into the bookmarks namespace.
+func openStorage(path string, log *logger.Logger) (*storage.FileStorage, error) {
+	store, err := storage.NewFileStorage(path, log)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize storage: %v", err)
+	}
+	if moved := store.AdoptUnnamespaced(twitter.BookmarksSourceName); moved > 0 {
+		log.Info("Moved %d processed bookmarks into the %s namespace of the cache", moved, twitter.BookmarksSourceName)
+	}
+	return store, nil
+}
+
+// loadAccount loads the configuration of the account called name, for the commands
+// that work on a single account.
+func loadAccount(name string) (*config.Config, *logger.Logger, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load configuration: %v", err)
+	}
+	account, err := cfg.FindAccount(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cfg.ForAccount(account), logger.New(cfg.LogLevel), nil
+}
+
+// Authorize runs the OAuth flow for the account called name on the terminal instead of
+// through the callback server, and saves the token file for the next start of the
+// application. The name may be empty if only one account is configured.
+func Authorize(name string) error {
+	cfg, log, err := loadAccount(name)
+	if err != nil {
+		return err
+	}
+	return twitter.AuthorizeHeadless(cfg, log, os.Stdin, os.Stdout)
+}
+
+// Logout revokes the stored Twitter token of the account called name and deletes its
+// token file.
+func Logout(name string) error {
+	cfg, log, err := loadAccount(name)
+	if err != nil {
+		return err
+	}
+	return twitter.Logout(cfg, log)
 }
 
 // Run starts the application.
 func (a *App) Run() {
-	a.Logger.Info("Starting application")
+	a.Logger.Info("Starting application with %d account(s)", len(a.Accounts))
 
 	// Setup web server
 	a.Mux.HandleFunc("/", a.handleDashboard)
 	a.Mux.HandleFunc("/api/metrics", a.handleMetrics)
+	a.Mux.HandleFunc("/logout", a.handleLogout)
 
 	port := a.Config.CallbackPort
 	server := &http.Server{
@@ -96,28 +231,19 @@ func (a *App) Run() {
 		}
 	}()
 
-	// Cleanup processed bookmarks if requested
-	if a.Config.CleanupProcessedBookmarks {
-		a.Logger.Info("Cleanup mode enabled - removing already processed bookmarks")
-		if err := a.Twitter.CleanupProcessedBookmarks(a.Storage); err != nil {
-			a.Logger.Error("Cleanup failed: %v", err)
-		} else {
-			a.Logger.Info("Cleanup completed successfully")
-		}
-		if err := a.Storage.Save(); err != nil {
-			a.Logger.Error("Error saving cache after cleanup: %v", err)
-		}
+	for _, account := range a.Accounts {
+		account.start()
 	}
 
-	go a.Scheduler.Start()
-
 	// Wait for shutdown signal
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
 	a.Logger.Info("Shutting down...")
-	a.Scheduler.Stop()
+	for _, account := range a.Accounts {
+		account.stop()
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -128,63 +254,373 @@ func (a *App) Run() {
 	a.Logger.Info("Application stopped")
 }
 
-func (a *App) processBookmarks() {
+// start starts the schedules of the account.
+func (a *Account) start() {
+	// Schedules keep running while the account is not authorized, skipping their runs;
+	// once authorization completes, the bookmarks are processed right away.
+	a.Twitter.OnAuthorized(func() {
+		a.Logger.Info("Twitter account authorized, resuming processing")
+		a.checkScopes()
+		a.cleanupOnce.Do(a.cleanupProcessedBookmarks)
+		a.processBookmarks()
+	})
+
+	go func() {
+		if a.Twitter.Authorized() {
+			a.checkScopes()
+			a.cleanupOnce.Do(a.cleanupProcessedBookmarks)
+		}
+		a.Scheduler.Start()
+	}()
+	for _, s := range a.SourceSchedulers {
+		go s.Start()
+	}
+}
+
+// stop stops the schedules of the account and the background token refresh.
+func (a *Account) stop() {
+	a.Scheduler.Stop()
+	for _, s := range a.SourceSchedulers {
+		s.Stop()
+	}
+	a.Twitter.Close()
+}
+
+// cleanupProcessedBookmarks removes already processed bookmarks if requested.
+func (a *Account) cleanupProcessedBookmarks() {
+	if !a.Config.CleanupProcessedBookmarks || !a.hasScope(twitter.ScopeBookmarkWrite) {
+		return
+	}
+
+	a.runMu.Lock()
+	defer a.runMu.Unlock()
+
+	a.Logger.Info("Cleanup mode enabled - removing already processed bookmarks")
+	if err := a.Twitter.CleanupProcessedBookmarks(storage.WithNamespace(a.Storage, twitter.BookmarksSourceName)); err != nil {
+		a.Logger.Error("Cleanup failed: %v", err)
+		var scopeErr *twitter.MissingScopeError
+		if errors.As(err, &scopeErr) {
+			a.disableScope(scopeErr.Scope)
+		}
+	} else {
+		a.Logger.Info("Cleanup completed successfully")
+	}
+	if err := a.Storage.Save(); err != nil {
+		a.Logger.Error("Error saving cache after cleanup: %v", err)
+	}
+}
+
+// processBookmarks runs the sources on the main schedule: the bookmarks, followed by the
+// liked tweets if enabled.
+func (a *Account) processBookmarks() {
+	a.runMu.Lock()
+	defer a.runMu.Unlock()
+
+	if !a.Twitter.Authorized() {
+		a.Logger.Warn("Skipping bookmark check, Twitter account is not authorized")
+		a.Metrics.UpdateStatus(statusNeedsAuthorization)
+		return
+	}
+
 	a.Logger.Info("Starting to process bookmarks")
 	a.Metrics.UpdateStatus("Processing")
+	a.Metrics.StartCheck()
+
+	nextCheck := time.Now().Add(a.Config.CheckInterval)
+	status := "Running"
+	processed := 0
+	for _, src := range a.Sources {
+		saved, reset, err := a.runSource(src, a.Scheduler)
+		processed += saved
+		if reset.After(nextCheck) {
+			nextCheck = reset
+		}
+		if errors.Is(err, twitter.ErrNotAuthorized) {
+			status = statusNeedsAuthorization
+			break
+		} else if errors.Is(err, apierr.ErrTransient) {
+			status = "Retrying"
+		} else if err != nil {
+			status = "Error"
+		}
+	}
+	if status == "Running" {
+		a.lastAlert = ""
+	}
 
-	tweets, err := a.Twitter.GetBookmarks()
+	if err := a.Storage.Save(); err != nil {
+		a.Logger.Error("Error saving cache: %v", err)
+	}
+
+	apiCalls := a.Metrics.RecordCheck(processed, processed, nextCheck)
+	a.Logger.Info("Check complete, saved %d items with %d API calls", processed, apiCalls)
+	if status == "Running" && nextCheck.After(time.Now().Add(a.Config.CheckInterval)) {
+		status = "Rate limited"
+	}
+	a.Metrics.UpdateStatus(status)
+}
+
+// deferOnRateLimit postpones the next run of s to the reset time if err is a rate limit
+// error, and returns that time.
+func (a *Account) deferOnRateLimit(err error, s scheduler.Scheduler) (time.Time, bool) {
+	var rateLimitErr *apierr.RateLimitError
+	if !errors.As(err, &rateLimitErr) {
+		return time.Time{}, false
+	}
+	a.Logger.Warn("%v, postponing the next run", err)
+	a.Metrics.RecordError(err.Error())
+	s.DelayUntil(rateLimitErr.Reset)
+	return rateLimitErr.Reset, true
+}
+
+// processSource runs a source that has its own schedule s, such as a List or search.
+func (a *Account) processSource(src source.Source, s scheduler.Scheduler) {
+	a.runMu.Lock()
+	defer a.runMu.Unlock()
+
+	if !a.Twitter.Authorized() {
+		a.Logger.Warn("Skipping source %s, Twitter account is not authorized", src.Name())
+		return
+	}
+
+	processed, _, _ := a.runSource(src, s)
+	if err := a.Storage.Save(); err != nil {
+		a.Logger.Error("Error saving cache: %v", err)
+	}
+	a.Metrics.RecordSaves(processed)
+}
+
+// scopedSource is implemented by sources that need an OAuth scope, and are skipped while
+// the features needing it are turned off.
+type scopedSource interface {
+	Scope() string
+}
+
+// runSource saves the items of src that its cache namespace has not seen yet, and returns
+// how many were saved. A rate limit, of X or of Dynalist, postpones the next run of s and
+// returns the reset time; the items fetched before the limit was hit are still saved. Any
+// other error that stopped the run is reported and returned. runMu must be held.
+func (a *Account) runSource(src source.Source, s scheduler.Scheduler) (int, time.Time, error) {
+	if scoped, ok := src.(scopedSource); ok && !a.hasScope(scoped.Scope()) {
+		a.Logger.Debug("Skipping source %s, it is turned off", src.Name())
+		return 0, time.Time{}, nil
+	}
+
+	a.Logger.Info("Starting to process source %s", src.Name())
+	store := storage.WithNamespace(a.Storage, src.Name())
+	items, err := src.Fetch(store)
+	reset, rateLimited := a.deferOnRateLimit(err, s)
+	if err != nil && !rateLimited {
+		a.reportError(fmt.Errorf("failed to get items for source %s: %w", src.Name(), err))
+		var scopeErr *twitter.MissingScopeError
+		if errors.As(err, &scopeErr) {
+			a.disableScope(scopeErr.Scope)
+		}
+		return 0, reset, err
+	}
+
+	a.Logger.Info("Found %d items in source %s", len(items), src.Name())
+	processed, skipped, failed, err := a.processItems(src, items, store)
 	if err != nil {
-		a.Logger.Error("failed to get bookmarks: %v", err)
-		a.Metrics.RecordError(err.Error())
-		a.Metrics.UpdateStatus("Error")
+		if saveReset, ok := a.deferOnRateLimit(err, s); ok {
+			reset, rateLimited = saveReset, true
+			err = nil
+		} else {
+			a.reportError(fmt.Errorf("stopped saving items of source %s: %w", src.Name(), err))
+		}
+	}
+
+	// Keep the cursor in place after a failure so the failed items are fetched again;
+	// the ones that were saved are skipped as already processed.
+	if committer, ok := src.(source.Committer); ok && failed == 0 && !rateLimited {
+		committer.Commit(store)
+	}
+	a.Logger.Info("Source %s processing complete. Processed: %d, Skipped: %d, Failed: %d", src.Name(), processed, skipped, failed)
+	return processed, reset, err
+}
+
+// reportError logs an error that stopped a run and shows it on the dashboard. Transient
+// failures are left to the next run to retry. Errors the user has to act on, such as a
+// rejected token or missing access, are also sent as an ntfy alert.
+func (a *Account) reportError(err error) {
+	if errors.Is(err, apierr.ErrTransient) {
+		a.Logger.Warn("%v, retrying on the next run", err)
 		return
 	}
+	a.Logger.Error("%v", err)
+	a.Metrics.RecordError(err.Error())
 
-	a.Logger.Info("Found %d bookmarked tweets", len(tweets))
+	if errors.Is(err, apierr.ErrInvalidToken) || errors.Is(err, apierr.ErrUnauthorized) ||
+		errors.Is(err, apierr.ErrForbidden) || errors.Is(err, apierr.ErrNotFound) {
+		a.alert(err)
+	}
+}
+
+// alert sends err as an ntfy notification, unless it was the last alert sent. runMu must
+// be held.
+func (a *Account) alert(err error) {
+	if err.Error() == a.lastAlert {
+		return
+	}
+	a.lastAlert = err.Error()
+	if sendErr := a.Ntfy.Send(err.Error(), "tw2dynalist Needs Attention"); sendErr != nil {
+		a.Logger.Warn("Failed to send ntfy alert: %v", sendErr)
+	}
+}
 
-	var processed, skipped, failed int
-	for _, tweet := range tweets {
-		if a.Storage.IsProcessed(tweet.ID) {
+// processItems saves every item that store has not seen yet to Dynalist, sends a
+// notification titled after the source's kind and lets the source acknowledge it. An item
+// that fails to save is retried on the next run, but when Dynalist is rate limited or
+// rejects the token, the remaining items would fail too, so processing stops with the error.
+// Items gone from their source are recorded as such instead.
+func (a *Account) processItems(src source.Source, items []source.Item, store storage.Storage) (processed, skipped, failed int, err error) {
+	acknowledger, _ := src.(source.Acknowledger)
+	for _, item := range items {
+		if item.Gone != "" {
+			a.markGone(item, store)
+			skipped++
+			continue
+		}
+		if store.IsProcessed(item.ID) {
 			skipped++
 			continue
 		}
 
-		content := fmt.Sprintf("Tweet: %s", tweet.Text)
-		note := fmt.Sprintf("URL: %s", tweet.URL)
-
-		if err := a.Dynalist.AddToInbox(content, note); err != nil {
-			a.Logger.Error("Error adding tweet %s to Dynalist: %v", tweet.ID, err)
+		node, err := a.saveItem(item)
+		if err != nil {
+			a.Logger.Error("Error adding item %s of source %s to Dynalist: %v", item.ID, src.Name(), err)
 			failed++
+			if errors.Is(err, apierr.ErrRateLimited) || errors.Is(err, apierr.ErrInvalidToken) {
+				return processed, skipped, failed, err
+			}
 			continue
 		}
 
-		a.Storage.MarkProcessed(tweet.ID)
+		store.MarkProcessed(item.ID)
+		rememberNode(store, item.ID, node)
 		processed++
 
-		if err := a.Ntfy.Send(tweet.Text, "New Bookmark Saved to Dynalist"); err != nil {
-			a.Logger.Warn("Failed to send ntfy notification for tweet %s: %v", tweet.ID, err)
+		if err := a.Ntfy.Send(item.Text, fmt.Sprintf("New %s Saved to Dynalist", src.Kind())); err != nil {
+			a.Logger.Warn("Failed to send ntfy notification for item %s: %v", item.ID, err)
 		}
 
-		if a.Config.RemoveBookmarks {
-			if err := a.Twitter.RemoveBookmark(tweet.ID); err != nil {
-				a.Logger.Warn("Failed to remove bookmark for tweet %s: %v", tweet.ID, err)
+		if acknowledger != nil {
+			if err := acknowledger.Acknowledge(item); err != nil {
+				a.Logger.Warn("Failed to acknowledge item %s with source %s: %v", item.ID, src.Name(), err)
+				var scopeErr *twitter.MissingScopeError
+				if errors.As(err, &scopeErr) {
+					a.disableScope(scopeErr.Scope)
+				}
 			}
 		}
 		time.Sleep(200 * time.Millisecond)
 	}
+	return processed, skipped, failed, nil
+}
 
-	if err := a.Storage.Save(); err != nil {
-		a.Logger.Error("Error saving cache: %v", err)
+// markGone records that an item saved earlier no longer exists at its source, and
+// annotates its Dynalist node with the reason if the node is known. Items that were never
+// saved have nothing to record. The item is recorded even if the annotation fails, which
+// is only logged: retrying it on every run would keep the source's cursor from moving.
+func (a *Account) markGone(item source.Item, store storage.Storage) {
+	if !store.IsProcessed(item.ID) {
+		a.Logger.Debug("Skipping item %s, it is %s", item.ID, item.Gone)
+		return
+	}
+	if store.IsGone(item.ID) {
+		return
+	}
+
+	if node, ok := store.GetNode(item.ID); ok {
+		err := a.Dynalist.Annotate(&dynalist.Node{FileID: node.FileID, NodeID: node.NodeID}, fmt.Sprintf("(%s)", item.Gone))
+		if errors.Is(err, apierr.ErrNotFound) {
+			// The node was removed from Dynalist, so there is nothing left to annotate.
+			a.Logger.Debug("Dynalist node of item %s no longer exists: %v", item.ID, err)
+		} else if err != nil {
+			a.Logger.Warn("Failed to annotate the Dynalist node of item %s as %s: %v", item.ID, item.Gone, err)
+		}
 	}
+	store.MarkGone(item.ID, item.Gone)
+	a.Logger.Info("Item %s is %s, marked it as gone", item.ID, item.Gone)
+}
 
-	a.Metrics.RecordCheck(processed, processed, time.Now().Add(a.Config.CheckInterval))
-	a.Metrics.UpdateStatus("Running")
-	a.Logger.Info("Bookmark processing complete. Processed: %d, Skipped: %d, Failed: %d", processed, skipped, failed)
+// rememberNode records the Dynalist node an item was saved as, if it is known.
+func rememberNode(store storage.Storage, itemID string, node *dynalist.Node) {
+	if node != nil && node.FileID != "" && node.NodeID != "" {
+		store.SetNode(itemID, storage.Node{FileID: node.FileID, NodeID: node.NodeID})
+	}
+}
+
+// saveItem adds the item, and its children if any, to Dynalist, and returns the node it
+// was saved as. Items from a folder with a configured destination go there; everything
+// else goes to the inbox.
+func (a *Account) saveItem(item source.Item) (*dynalist.Node, error) {
+	content := item.Text
+	if item.Prefix != "" {
+		content = fmt.Sprintf("%s: %s", item.Prefix, item.Text)
+	}
+	note := buildNote(item)
+
+	var node *dynalist.Node
+	var err error
+	if dest, ok := a.Config.FolderDestinations[item.Folder]; ok && item.Folder != "" {
+		node, err = a.Dynalist.AddToDocument(dest.FileID, dest.NodeID, content, note)
+	} else {
+		node, err = a.Dynalist.AddToInbox(content, note)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(item.Children) > 0 {
+		children := make([]dynalist.Item, 0, len(item.Children))
+		for _, child := range item.Children {
+			children = append(children, dynalist.Item{
+				Content: child.Text,
+				Note:    fmt.Sprintf("URL: %s", child.URL),
+			})
+		}
+		// The item itself is already saved, so a failure here only loses its children.
+		if err := a.Dynalist.AddChildren(node, children); err != nil {
+			a.Logger.Warn("Failed to add children of item %s to Dynalist: %v", item.ID, err)
+		}
+	}
+	return node, nil
+}
+
+// buildNote builds the Dynalist note for an item: its URL, referenced items and attachments.
+func buildNote(item source.Item) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "URL: %s", item.URL)
+	for _, ref := range item.References {
+		author := ref.Item.Author
+		if author == "" {
+			author = "unknown"
+		}
+		fmt.Fprintf(&b, "\n\n%s @%s: %s\nURL: %s", ref.Label, author, ref.Item.Text, ref.Item.URL)
+	}
+	if len(item.Attachments) > 0 {
+		b.WriteString("\n\nMedia:")
+		for _, attachment := range item.Attachments {
+			fmt.Fprintf(&b, "\n- %s: %s", attachment.Type, attachment.URL)
+			if attachment.Description != "" {
+				fmt.Fprintf(&b, " (%s)", attachment.Description)
+			}
+		}
+	}
+	return b.String()
 }
 
 // Metrics holds application status and metrics.
 type Metrics struct {
-	mu                      sync.Mutex
+	mu sync.Mutex
+	MetricsSnapshot
+	// checkAPICalls counts the API calls since the current check started.
+	checkAPICalls int
+}
+
+// MetricsSnapshot is a point-in-time copy of the metrics values, safe to read without locking.
+type MetricsSnapshot struct {
 	StartTime               time.Time
 	LastCheckTime           *time.Time
 	NextCheckTime           *time.Time
@@ -196,14 +632,26 @@ type Metrics struct {
 	CheckInterval           time.Duration
 	TokenExpiresAt          *time.Time
 	TokenRefreshCount       int
+	LastFetchPages          int
+	LastFetchTweets         int
+	LastFetchUnseen         int
+	// LastCheckAPICalls is the number of Twitter API calls the last check made.
+	LastCheckAPICalls int
+	TotalAPICalls     int
+	// RateLimits is the remaining request budget per Twitter API endpoint.
+	RateLimits map[string]twitter.RateLimit
+	// DisabledFeatures lists the features turned off for lack of an OAuth scope.
+	DisabledFeatures []string
 }
 
 // NewMetrics creates a new Metrics struct.
 func NewMetrics(checkInterval time.Duration) *Metrics {
 	return &Metrics{
-		StartTime:     time.Now(),
-		Status:        "Starting",
-		CheckInterval: checkInterval,
+		MetricsSnapshot: MetricsSnapshot{
+			StartTime:     time.Now(),
+			Status:        "Starting",
+			CheckInterval: checkInterval,
+		},
 	}
 }
 
@@ -213,14 +661,31 @@ func (m *Metrics) UpdateStatus(status string) {
 	m.Status = status
 }
 
-func (m *Metrics) RecordCheck(bookmarksProcessed, dynalistSaves int, nextCheck time.Time) {
+// StartCheck starts counting the API calls of a scheduled check.
+func (m *Metrics) StartCheck() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.checkAPICalls = 0
+}
+
+// RecordCheck records a completed check and returns the number of API calls it made.
+func (m *Metrics) RecordCheck(bookmarksProcessed, dynalistSaves int, nextCheck time.Time) int {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	now := time.Now()
+	m.LastCheckAPICalls = m.checkAPICalls
 	m.LastCheckTime = &now
 	m.NextCheckTime = &nextCheck
 	m.TotalBookmarksProcessed += bookmarksProcessed
 	m.TotalDynalistSaves += dynalistSaves
+	return m.LastCheckAPICalls
+}
+
+// RecordSaves adds items saved outside of a scheduled bookmark check to the totals.
+func (m *Metrics) RecordSaves(dynalistSaves int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.TotalDynalistSaves += dynalistSaves
 }
 
 func (m *Metrics) RecordError(err string) {
@@ -231,11 +696,73 @@ func (m *Metrics) RecordError(err string) {
 	m.LastErrorTime = &now
 }
 
-func (m *Metrics) GetSafeCopy() Metrics {
+// RecordBookmarkPage records the progress of a single bookmarks page. Page 1 starts a new fetch.
+func (m *Metrics) RecordBookmarkPage(page, tweets, unseen int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if page == 1 {
+		m.LastFetchTweets = 0
+		m.LastFetchUnseen = 0
+	}
+	m.LastFetchPages = page
+	m.LastFetchTweets += tweets
+	m.LastFetchUnseen += unseen
+}
+
+// SetDisabledFeatures replaces the list of features turned off for lack of an OAuth scope.
+func (m *Metrics) SetDisabledFeatures(features []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.DisabledFeatures = features
+}
+
+// RecordTokenExpiry records when the current Twitter OAuth token expires.
+func (m *Metrics) RecordTokenExpiry(expiresAt time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if expiresAt.IsZero() {
+		m.TokenExpiresAt = nil
+		return
+	}
+	m.TokenExpiresAt = &expiresAt
+}
+
+// RecordTokenRefresh records a refreshed Twitter OAuth token.
+func (m *Metrics) RecordTokenRefresh(expiresAt time.Time) {
+	m.RecordTokenExpiry(expiresAt)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.TokenRefreshCount++
+}
+
+// RecordAPICall counts a request sent to a Twitter API endpoint.
+func (m *Metrics) RecordAPICall(endpoint string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.checkAPICalls++
+	m.TotalAPICalls++
+}
+
+// RecordRateLimit records the remaining request budget of a Twitter API endpoint.
+func (m *Metrics) RecordRateLimit(endpoint string, rl twitter.RateLimit) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.RateLimits == nil {
+		m.RateLimits = make(map[string]twitter.RateLimit)
+	}
+	m.RateLimits[endpoint] = rl
+}
+
+func (m *Metrics) GetSafeCopy() MetricsSnapshot {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	// Create a copy to avoid race conditions on the caller's side
-	return *m
+	snapshot := m.MetricsSnapshot
+	snapshot.RateLimits = make(map[string]twitter.RateLimit, len(m.RateLimits))
+	for endpoint, rl := range m.RateLimits {
+		snapshot.RateLimits[endpoint] = rl
+	}
+	return snapshot
 }
 
 func (a *App) handleDashboard(w http.ResponseWriter, r *http.Request) {
@@ -244,14 +771,65 @@ func (a *App) handleDashboard(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	w.Header().Set("Content-Type", "text/html")
-	metrics := a.Metrics.GetSafeCopy()
-	html := getDashboardHTML(metrics)
-	w.Write([]byte(html))
+	var sections strings.Builder
+	for _, account := range a.Accounts {
+		sections.WriteString(getAccountHTML(account.Name, account.Metrics.GetSafeCopy(), account.Twitter.Authorized()))
+	}
+	w.Write([]byte(getDashboardHTML(sections.String())))
 }
 
+// findAccount returns the account called name, or nil if there is none.
+func (a *App) findAccount(name string) *Account {
+	for _, account := range a.Accounts {
+		if account.Name == name {
+			return account
+		}
+	}
+	return nil
+}
+
+// handleLogout disconnects the Twitter account named in the account form field. It only
+// accepts POST requests from the dashboard itself, so other sites cannot log the app out.
+func (a *App) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+			http.Error(w, "Cross-origin request rejected", http.StatusForbidden)
+			return
+		}
+	}
+	account := a.findAccount(r.FormValue("account"))
+	if account == nil {
+		http.Error(w, "Unknown account", http.StatusNotFound)
+		return
+	}
+
+	if err := account.Twitter.Logout(); err != nil {
+		account.Logger.Error("Logout: %v", err)
+		account.Metrics.RecordError(err.Error())
+	}
+	account.Metrics.UpdateStatus(statusNeedsAuthorization)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// handleMetrics serves the metrics as JSON. With ACCOUNTS configured, they are keyed by
+// account name.
 func (a *App) handleMetrics(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	metrics := a.Metrics.GetSafeCopy()
+	var metrics interface{}
+	if len(a.Accounts) == 1 && a.Accounts[0].Name == "" {
+		metrics = a.Accounts[0].Metrics.GetSafeCopy()
+	} else {
+		byAccount := make(map[string]MetricsSnapshot, len(a.Accounts))
+		for _, account := range a.Accounts {
+			byAccount[account.Name] = account.Metrics.GetSafeCopy()
+		}
+		metrics = byAccount
+	}
 	jsonData, err := json.MarshalIndent(metrics, "", "  ")
 	if err != nil {
 		http.Error(w, "Failed to encode metrics", http.StatusInternalServerError)
@@ -260,7 +838,7 @@ func (a *App) handleMetrics(w http.ResponseWriter, r *http.Request) {
 	w.Write(jsonData)
 }
 
-func getDashboardHTML(metrics Metrics) string {
+func getDashboardHTML(accounts string) string {
 	// This function can be copied from the original main.go and adapted.
 	// For brevity, I'm using a simplified version here. A more complete implementation
 	// would involve moving the original HTML generation logic here.
@@ -272,30 +850,82 @@ func getDashboardHTML(metrics Metrics) string {
 </head>
 <body>
     <h1>Twitter to Dynalist Bot Status</h1>
+%s</body>
+</html>`, accounts)
+}
+
+// getAccountHTML renders the status of one account. The account heading is left out in
+// single-account mode, where name is empty.
+func getAccountHTML(name string, metrics MetricsSnapshot, authorized bool) string {
+	authorizeLink := html.EscapeString(twitter.AuthorizeLink(name))
+	authorization := fmt.Sprintf(`<form method="post" action="/logout"><input type="hidden" name="account" value="%s"><button type="submit">Log out of X</button></form>`, html.EscapeString(name))
+	if !authorized {
+		authorization = fmt.Sprintf(`<p><strong>The Twitter account needs to be authorized.</strong> <a href="%s">Authorize with X</a></p>`, authorizeLink)
+	} else if len(metrics.DisabledFeatures) > 0 {
+		authorization = fmt.Sprintf(`<p><strong>Turned off for missing permissions:</strong> %s. <a href="%s">Authorize with X</a> again to grant them.</p>`,
+			html.EscapeString(strings.Join(metrics.DisabledFeatures, ", ")), authorizeLink) + authorization
+	}
+
+	heading := ""
+	if name != "" {
+		heading = fmt.Sprintf("    <h2>Account %s</h2>\n", html.EscapeString(name))
+	}
+	return fmt.Sprintf(`%s    %s
     <p>Status: %s</p>
     <p>Uptime: %s</p>
     <p>Last Check: %s</p>
     <p>Next Check: %s</p>
     <p>Total Bookmarks Processed: %d</p>
     <p>Total Dynalist Saves: %d</p>
+    <p>Last Fetch: %d pages, %d bookmarks, %d new</p>
+    <p>API Calls: %d in the last check, %d in total</p>
+    <p>Token Expires: %s (refreshed %d times)</p>
     <p>Last Error: %s</p>
-</body>
-</html>`,
-		metrics.Status,
+    <h3>API Rate Limits</h3>
+    <ul>%s</ul>
+`,
+		heading,
+		authorization,
+		html.EscapeString(metrics.Status),
 		time.Since(metrics.StartTime).Round(time.Second),
 		formatOptionalTime(metrics.LastCheckTime, "Never"),
 		formatOptionalTime(metrics.NextCheckTime, "Not scheduled"),
 		metrics.TotalBookmarksProcessed,
 		metrics.TotalDynalistSaves,
-		metrics.LastError,
+		metrics.LastFetchPages,
+		metrics.LastFetchTweets,
+		metrics.LastFetchUnseen,
+		metrics.LastCheckAPICalls,
+		metrics.TotalAPICalls,
+		formatOptionalTime(metrics.TokenExpiresAt, "Unknown"),
+		metrics.TokenRefreshCount,
+		html.EscapeString(metrics.LastError),
+		formatRateLimits(metrics.RateLimits),
 	)
 }
 
+// formatRateLimits renders the remaining budget of each endpoint as HTML list items.
+func formatRateLimits(rateLimits map[string]twitter.RateLimit) string {
+	if len(rateLimits) == 0 {
+		return "<li>No requests made yet</li>"
+	}
+	endpoints := make([]string, 0, len(rateLimits))
+	for endpoint := range rateLimits {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Strings(endpoints)
+
+	var b strings.Builder
+	for _, endpoint := range endpoints {
+		rl := rateLimits[endpoint]
+		fmt.Fprintf(&b, "<li>%s: %d/%d remaining, resets %s</li>", html.EscapeString(endpoint), rl.Remaining, rl.Limit, rl.Reset.Format(time.RFC1123))
+	}
+	return b.String()
+}
+
 func formatOptionalTime(t *time.Time, defaultStr string) string {
 	if t == nil {
 		return defaultStr
 	}
 	return t.Format(time.RFC1123)
 }
-
-
@@ -0,0 +1,105 @@
+package app
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/korjavin/tw2dynalist/internal/source"
+	"github.com/korjavin/tw2dynalist/internal/storage"
+)
+
+// mockSource is a source whose items are given by the test.
+type mockSource struct {
+	items []source.Item
+}
+
+func (s *mockSource) Name() string { return "mock" }
+func (s *mockSource) Kind() string { return "Item" }
+func (s *mockSource) Fetch(store storage.Storage) ([]source.Item, error) {
+	return s.items, nil
+}
+
+func TestProcessItems_Gone(t *testing.T) {
+	dl := &mockDynalist{}
+	a := newTestAccount(t, filepath.Join(t.TempDir(), "cache.json"), dl)
+	store := storage.WithNamespace(a.Storage, "mock")
+	store.MarkProcessed("1")
+	store.SetNode("1", storage.Node{FileID: "inbox", NodeID: "node1"})
+	store.MarkProcessed("2")
+
+	src := &mockSource{items: []source.Item{
+		{ID: "1", Gone: "deleted on X"},
+		{ID: "2", Gone: "protected on X"},
+		{ID: "3", Gone: "deleted on X"},
+	}}
+	processed, skipped, failed, err := a.processItems(src, src.items, store)
+	if err != nil || processed != 0 || skipped != 3 || failed != 0 {
+		t.Fatalf("Expected 3 skipped items, got processed=%d skipped=%d failed=%d err=%v", processed, skipped, failed, err)
+	}
+	if len(dl.annotated) != 1 || dl.annotated[0] != "node1 (deleted on X)" {
+		t.Errorf("Expected only the node of item 1 to be annotated, got %v", dl.annotated)
+	}
+	if !store.IsGone("1") || !store.IsGone("2") {
+		t.Error("Expected the saved items to be marked as gone")
+	}
+	if store.IsGone("3") {
+		t.Error("Expected an item that was never saved not to be marked as gone")
+	}
+
+	// A gone item is only annotated once.
+	a.processItems(src, src.items, store)
+	if len(dl.annotated) != 1 {
+		t.Errorf("Expected no new annotations, got %v", dl.annotated)
+	}
+}
+
+func TestMarkGone_AnnotationFails(t *testing.T) {
+	dl := &mockDynalist{annotateErr: errors.New("dynalist is down")}
+	a := newTestAccount(t, filepath.Join(t.TempDir(), "cache.json"), dl)
+	store := storage.WithNamespace(a.Storage, "mock")
+	store.MarkProcessed("1")
+	store.SetNode("1", storage.Node{FileID: "inbox", NodeID: "node1"})
+
+	src := &mockSource{items: []source.Item{{ID: "1", Gone: "deleted on X"}}}
+	_, skipped, failed, err := a.processItems(src, src.items, store)
+	if err != nil || failed != 0 || skipped != 1 {
+		t.Errorf("Expected a failed annotation not to fail the item, got skipped=%d failed=%d err=%v", skipped, failed, err)
+	}
+	if !store.IsGone("1") {
+		t.Error("Expected the item to be marked as gone even though the annotation failed")
+	}
+}
+
+func TestSaveItem_Prefix(t *testing.T) {
+	dl := &mockDynalist{}
+	a := newTestAccount(t, filepath.Join(t.TempDir(), "cache.json"), dl)
+
+	for _, item := range []source.Item{
+		{ID: "1", Prefix: "Tweet", Text: "hello"},
+		{ID: "2", Text: "no prefix"},
+	} {
+		if _, err := a.saveItem(item); err != nil {
+			t.Fatalf("saveItem() returned an error: %v", err)
+		}
+	}
+	if len(dl.saved) != 2 || dl.saved[0] != "Tweet: hello" || dl.saved[1] != "no prefix" {
+		t.Errorf("Expected the prefix in front of the text only if set, got %v", dl.saved)
+	}
+}
+
+func TestGetAccountHTML_EscapesErrors(t *testing.T) {
+	metrics := NewMetrics(time.Hour).GetSafeCopy()
+	metrics.Status = "Error <b>"
+	metrics.LastError = `failed to revoke token: <script>alert("x")</script>`
+
+	page := getAccountHTML("alice", metrics, true)
+	if strings.Contains(page, "<script>") || strings.Contains(page, "<b>") {
+		t.Errorf("Expected the status and last error to be escaped, got %s", page)
+	}
+	if !strings.Contains(page, "&lt;script&gt;") {
+		t.Errorf("Expected the escaped error on the page, got %s", page)
+	}
+}
@@ -0,0 +1,124 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/korjavin/tw2dynalist/internal/dynalist"
+	"github.com/korjavin/tw2dynalist/internal/storage"
+	"github.com/korjavin/tw2dynalist/internal/twitter"
+)
+
+// archiveCursor is the cursor key under which an archive import keeps the ID of the last
+// liked tweet it handled, so that an interrupted import resumes after it.
+const archiveCursor = "archive_import"
+
+// archiveSaveInterval is how many imported tweets are written to the cache file at once.
+// An import that is stopped with a signal saves its progress first; one that crashes saves
+// the tweets imported since the last write again.
+const archiveSaveInterval = 25
+
+// ImportArchive saves the liked tweets in an X data archive to Dynalist for the account
+// called name, waiting delay after each saved tweet. Likes that are already processed,
+// whether by an earlier import or by SYNC_LIKES, are skipped. The import stops at the
+// first tweet that cannot be saved; running it again resumes from there.
+func ImportArchive(archivePath, name string, delay time.Duration) error {
+	cfg, log, err := loadAccount(name)
+	if err != nil {
+		return err
+	}
+
+	archive, closeArchive, err := twitter.OpenArchive(archivePath)
+	if err != nil {
+		return err
+	}
+	defer closeArchive()
+	tweets, err := twitter.ReadArchiveLikes(archive)
+	if err != nil {
+		return err
+	}
+	log.Info("Found %d liked tweets in %s", len(tweets), archivePath)
+
+	store, err := openStorage(cfg.CacheFilePath, log)
+	if err != nil {
+		return err
+	}
+	a := &Account{
+		Name:     cfg.AccountName,
+		Config:   cfg,
+		Logger:   log,
+		Storage:  store,
+		Dynalist: dynalist.NewClient(cfg.DynalistAPIURL, cfg.DynalistToken, log),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return a.importLikes(ctx, tweets, delay)
+}
+
+// importLikes saves the archived liked tweets in order, starting after the archive cursor
+// if it is found among them. Notifications are not sent, as an archive holds years of likes.
+// When ctx is cancelled, the progress is saved and the import stops.
+func (a *Account) importLikes(ctx context.Context, tweets []twitter.Tweet, delay time.Duration) error {
+	store := storage.WithNamespace(a.Storage, twitter.LikesSourceName)
+	start := 0
+	if last := store.GetCursor(archiveCursor); last != "" {
+		for i, tweet := range tweets {
+			if tweet.ID == last {
+				start = i + 1
+				a.Logger.Info("Resuming archive import after tweet %s, %d of %d tweets already handled", last, start, len(tweets))
+				break
+			}
+		}
+	}
+
+	var imported, skipped int
+	for _, tweet := range tweets[start:] {
+		if ctx.Err() != nil {
+			if err := a.Storage.Save(); err != nil {
+				a.Logger.Error("Error saving cache: %v", err)
+			}
+			return fmt.Errorf("import interrupted after importing %d, run it again to resume", imported)
+		}
+		if store.IsProcessed(tweet.ID) {
+			skipped++
+			store.SetCursor(archiveCursor, tweet.ID)
+			continue
+		}
+
+		node, err := a.saveItem(tweet.Item(twitter.LikesSourceName))
+		if err != nil {
+			if saveErr := a.Storage.Save(); saveErr != nil {
+				a.Logger.Error("Error saving cache: %v", saveErr)
+			}
+			return fmt.Errorf("failed to save liked tweet %s after importing %d, run the import again to resume: %w", tweet.ID, imported, err)
+		}
+		store.MarkProcessed(tweet.ID)
+		rememberNode(store, tweet.ID, node)
+		store.SetCursor(archiveCursor, tweet.ID)
+		imported++
+
+		if imported%archiveSaveInterval == 0 {
+			if err := a.Storage.Save(); err != nil {
+				return fmt.Errorf("failed to save cache: %v", err)
+			}
+			a.Logger.Info("Imported %d liked tweets so far", imported)
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
+	}
+
+	// A later archive starts with newer likes, so its import must not skip ahead.
+	store.SetCursor(archiveCursor, "")
+	if err := a.Storage.Save(); err != nil {
+		return fmt.Errorf("failed to save cache: %v", err)
+	}
+	a.Logger.Info("Archive import complete. Imported: %d, Skipped: %d", imported, skipped)
+	return nil
+}
@@ -0,0 +1,131 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/korjavin/tw2dynalist/internal/config"
+	"github.com/korjavin/tw2dynalist/internal/dynalist"
+	"github.com/korjavin/tw2dynalist/internal/logger"
+	"github.com/korjavin/tw2dynalist/internal/storage"
+	"github.com/korjavin/tw2dynalist/internal/twitter"
+)
+
+// mockDynalist records the content of the items added to the inbox and the annotations,
+// and fails for content containing failOn, if set, and for annotations if annotateErr is.
+type mockDynalist struct {
+	saved       []string
+	failOn      string
+	annotated   []string
+	annotateErr error
+}
+
+func (m *mockDynalist) AddToInbox(content, note string) (*dynalist.Node, error) {
+	if m.failOn != "" && strings.Contains(content, m.failOn) {
+		return nil, errors.New("dynalist is down")
+	}
+	m.saved = append(m.saved, content)
+	return &dynalist.Node{FileID: "inbox", NodeID: "node" + content}, nil
+}
+
+func (m *mockDynalist) AddToDocument(fileID, parentID, content, note string) (*dynalist.Node, error) {
+	return m.AddToInbox(content, note)
+}
+
+func (m *mockDynalist) AddChildren(parent *dynalist.Node, children []dynalist.Item) error {
+	return nil
+}
+
+func (m *mockDynalist) Annotate(node *dynalist.Node, annotation string) error {
+	if m.annotateErr != nil {
+		return m.annotateErr
+	}
+	m.annotated = append(m.annotated, node.NodeID+" "+annotation)
+	return nil
+}
+
+func newTestAccount(t *testing.T, cacheFile string, dl dynalist.Client) *Account {
+	log := logger.New("DEBUG")
+	store, err := storage.NewFileStorage(cacheFile, log)
+	if err != nil {
+		t.Fatalf("NewFileStorage() returned an error: %v", err)
+	}
+	return &Account{
+		Config:   &config.Config{},
+		Logger:   log,
+		Storage:  store,
+		Dynalist: dl,
+	}
+}
+
+func archiveTweets(ids ...string) []twitter.Tweet {
+	tweets := make([]twitter.Tweet, 0, len(ids))
+	for _, id := range ids {
+		tweets = append(tweets, twitter.Tweet{ID: id, Text: "like " + id})
+	}
+	return tweets
+}
+
+func TestImportLikes_Resume(t *testing.T) {
+	cacheFile := filepath.Join(t.TempDir(), "cache.json")
+	tweets := archiveTweets("1", "2", "3", "4")
+
+	// The first import stops at the tweet that cannot be saved.
+	failing := &mockDynalist{failOn: "like 3"}
+	a := newTestAccount(t, cacheFile, failing)
+	if err := a.importLikes(context.Background(), tweets, 0); err == nil {
+		t.Fatal("Expected an error for the tweet that failed to save")
+	}
+	if len(failing.saved) != 2 {
+		t.Fatalf("Expected tweets 1 and 2 to be saved, got %v", failing.saved)
+	}
+
+	// SYNC_LIKES saved tweet 4 in the meantime.
+	a = newTestAccount(t, cacheFile, nil)
+	likes := storage.WithNamespace(a.Storage, twitter.LikesSourceName)
+	if got := likes.GetCursor(archiveCursor); got != "2" {
+		t.Fatalf("Expected the cursor to be kept at tweet '2', got '%s'", got)
+	}
+	likes.MarkProcessed("4")
+
+	// Tweet 0 is not processed, but comes before the cursor, so the import resumes after it.
+	working := &mockDynalist{}
+	a.Dynalist = working
+	if err := a.importLikes(context.Background(), append(archiveTweets("0"), tweets...), 0); err != nil {
+		t.Fatalf("importLikes() returned an error: %v", err)
+	}
+	if len(working.saved) != 1 || working.saved[0] != "Tweet: like 3" {
+		t.Errorf("Expected only tweet 3 to be saved on resume, got %v", working.saved)
+	}
+	if got := likes.GetCursor(archiveCursor); got != "" {
+		t.Errorf("Expected the cursor to be cleared after the import, got '%s'", got)
+	}
+	if node, ok := likes.GetNode("3"); !ok || node.NodeID != "nodeTweet: like 3" {
+		t.Errorf("Expected the node of tweet 3 to be recorded, got %+v", node)
+	}
+
+	loaded := newTestAccount(t, cacheFile, nil)
+	for _, id := range []string{"1", "2", "3", "4"} {
+		if !storage.WithNamespace(loaded.Storage, twitter.LikesSourceName).IsProcessed(id) {
+			t.Errorf("Expected tweet %s to be processed in the saved cache", id)
+		}
+	}
+}
+
+func TestImportLikes_Interrupted(t *testing.T) {
+	cacheFile := filepath.Join(t.TempDir(), "cache.json")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	dl := &mockDynalist{}
+	a := newTestAccount(t, cacheFile, dl)
+	if err := a.importLikes(ctx, archiveTweets("1", "2"), 0); err == nil {
+		t.Fatal("Expected an error for an interrupted import")
+	}
+	if len(dl.saved) != 0 {
+		t.Errorf("Expected nothing to be saved after the interruption, got %v", dl.saved)
+	}
+}
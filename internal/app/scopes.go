@@ -0,0 +1,115 @@
+package app
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+
+	"github.com/korjavin/tw2dynalist/internal/config"
+	"github.com/korjavin/tw2dynalist/internal/twitter"
+)
+
+// scopeFeature is an optional feature that needs an OAuth scope beyond reading bookmarks.
+type scopeFeature struct {
+	scope   string
+	name    string
+	enabled func(cfg *config.Config) bool
+}
+
+var scopeFeatures = []scopeFeature{
+	{twitter.ScopeBookmarkWrite, "removing bookmarks", func(cfg *config.Config) bool {
+		return cfg.RemoveBookmarks || cfg.CleanupProcessedBookmarks
+	}},
+	{twitter.ScopeLikeRead, "syncing likes", func(cfg *config.Config) bool {
+		return cfg.SyncLikes
+	}},
+	{twitter.ScopeListRead, "List sources", func(cfg *config.Config) bool {
+		for _, source := range cfg.Sources {
+			if source.Type == config.SourceTypeList {
+				return true
+			}
+		}
+		return false
+	}},
+}
+
+// checkScopes compares the granted scopes with the enabled features, and turns off the
+// features whose scope is missing until the next authorization. If the granted scopes are
+// not known, all features stay on.
+func (a *Account) checkScopes() {
+	granted := a.Twitter.GrantedScopes()
+	a.scopeMu.Lock()
+	a.missingScopes = make(map[string]bool)
+	a.scopeMu.Unlock()
+	if granted == nil {
+		a.Logger.Debug("Granted scopes are not known, assuming all scopes are granted")
+		a.Metrics.SetDisabledFeatures(nil)
+		return
+	}
+
+	have := make(map[string]bool, len(granted))
+	for _, scope := range granted {
+		have[scope] = true
+	}
+	if !have[twitter.ScopeBookmarkRead] {
+		a.Logger.Warn("The authorization lacks the %s scope, bookmarks cannot be read. Re-authorize at %s", twitter.ScopeBookmarkRead, a.authorizeURL())
+	}
+	for _, feature := range scopeFeatures {
+		if feature.enabled(a.Config) && !have[feature.scope] {
+			a.disableScope(feature.scope)
+		}
+	}
+}
+
+// disableScope turns off the features that need scope, warning once.
+func (a *Account) disableScope(scope string) {
+	a.scopeMu.Lock()
+	if a.missingScopes[scope] {
+		a.scopeMu.Unlock()
+		return
+	}
+	if a.missingScopes == nil {
+		a.missingScopes = make(map[string]bool)
+	}
+	a.missingScopes[scope] = true
+	disabled := a.disabledFeatures()
+	a.scopeMu.Unlock()
+
+	for _, feature := range scopeFeatures {
+		if feature.scope == scope && feature.enabled(a.Config) {
+			a.Logger.Warn("Turned off %s: the authorization lacks the %s scope. Re-authorize at %s to turn it back on", feature.name, scope, a.authorizeURL())
+		}
+	}
+	a.Metrics.SetDisabledFeatures(disabled)
+}
+
+// hasScope reports whether the features needing scope may be used.
+func (a *Account) hasScope(scope string) bool {
+	a.scopeMu.Lock()
+	defer a.scopeMu.Unlock()
+	return !a.missingScopes[scope]
+}
+
+// disabledFeatures describes the enabled features that are turned off for a missing
+// scope. scopeMu must be held.
+func (a *Account) disabledFeatures() []string {
+	var disabled []string
+	for _, feature := range scopeFeatures {
+		if a.missingScopes[feature.scope] && feature.enabled(a.Config) {
+			disabled = append(disabled, fmt.Sprintf("%s (missing %s scope)", feature.name, feature.scope))
+		}
+	}
+	sort.Strings(disabled)
+	return disabled
+}
+
+// authorizeURL returns the dashboard URL that starts a new authorization of the account,
+// on the scheme and host of the configured callback URL. Without a usable callback URL
+// only the path is returned.
+func (a *Account) authorizeURL() string {
+	redirect, err := url.Parse(a.Config.TwitterRedirectURL)
+	if err != nil || redirect.Scheme == "" || redirect.Host == "" {
+		return twitter.AuthorizeLink(a.Name)
+	}
+	return (&url.URL{Scheme: redirect.Scheme, Host: redirect.Host}).String() + twitter.AuthorizeLink(a.Name)
+}
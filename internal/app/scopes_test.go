@@ -0,0 +1,105 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/korjavin/tw2dynalist/internal/config"
+	"github.com/korjavin/tw2dynalist/internal/logger"
+	"github.com/korjavin/tw2dynalist/internal/twitter"
+)
+
+// mockTwitter reports the granted scopes. The other methods of twitter.Client are not
+// used by these tests.
+type mockTwitter struct {
+	twitter.Client
+	granted []string
+}
+
+func (m *mockTwitter) GrantedScopes() []string { return m.granted }
+
+func newScopeTestAccount(cfg *config.Config, granted []string) *Account {
+	return &Account{
+		Name:    "work",
+		Config:  cfg,
+		Logger:  logger.New("DEBUG"),
+		Twitter: &mockTwitter{granted: granted},
+		Metrics: NewMetrics(0),
+	}
+}
+
+func TestCheckScopes(t *testing.T) {
+	cfg := &config.Config{
+		SyncLikes:       true,
+		RemoveBookmarks: true,
+		Sources:         []config.SourceConfig{{Type: config.SourceTypeList, Target: "123"}},
+	}
+	a := newScopeTestAccount(cfg, []string{twitter.ScopeTweetRead, twitter.ScopeBookmarkRead, twitter.ScopeLikeRead})
+	a.checkScopes()
+
+	if !a.hasScope(twitter.ScopeLikeRead) {
+		t.Error("Expected syncing likes to stay on with the like.read scope granted")
+	}
+	if a.hasScope(twitter.ScopeBookmarkWrite) || a.hasScope(twitter.ScopeListRead) {
+		t.Error("Expected the features of the missing scopes to be turned off")
+	}
+	want := []string{"List sources (missing list.read scope)", "removing bookmarks (missing bookmark.write scope)"}
+	if got := a.Metrics.DisabledFeatures; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected disabled features %v, got %v", want, got)
+	}
+
+	// A new authorization with all scopes turns the features back on.
+	a.Twitter = &mockTwitter{granted: []string{twitter.ScopeBookmarkWrite, twitter.ScopeListRead}}
+	a.checkScopes()
+	if !a.hasScope(twitter.ScopeBookmarkWrite) || !a.hasScope(twitter.ScopeListRead) {
+		t.Error("Expected the features to be turned back on after re-authorization")
+	}
+}
+
+func TestCheckScopes_Unknown(t *testing.T) {
+	a := newScopeTestAccount(&config.Config{SyncLikes: true}, nil)
+	a.checkScopes()
+	if !a.hasScope(twitter.ScopeLikeRead) {
+		t.Error("Expected all features to stay on when the granted scopes are not known")
+	}
+	if len(a.Metrics.DisabledFeatures) != 0 {
+		t.Errorf("Expected no disabled features, got %v", a.Metrics.DisabledFeatures)
+	}
+}
+
+func TestDisableScope(t *testing.T) {
+	a := newScopeTestAccount(&config.Config{SyncLikes: true}, nil)
+	if !a.hasScope(twitter.ScopeLikeRead) {
+		t.Fatal("Expected all scopes to be usable before any is disabled")
+	}
+
+	a.disableScope(twitter.ScopeLikeRead)
+	a.disableScope(twitter.ScopeLikeRead)
+	if a.hasScope(twitter.ScopeLikeRead) {
+		t.Error("Expected syncing likes to be turned off")
+	}
+	if !a.hasScope(twitter.ScopeBookmarkWrite) {
+		t.Error("Expected other scopes to stay usable")
+	}
+	want := []string{"syncing likes (missing like.read scope)"}
+	if got := a.Metrics.DisabledFeatures; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected disabled features %v, got %v", want, got)
+	}
+}
+
+func TestAuthorizeURL(t *testing.T) {
+	tests := []struct {
+		redirectURL string
+		want        string
+	}{
+		{"http://localhost:8080/callback", "http://localhost:8080/authorize?account=work"},
+		{"https://example.com/oauth/x/return", "https://example.com/authorize?account=work"},
+		{"", "/authorize?account=work"},
+	}
+	for _, tt := range tests {
+		a := newScopeTestAccount(&config.Config{TwitterRedirectURL: tt.redirectURL}, nil)
+		if got := a.authorizeURL(); got != tt.want {
+			t.Errorf("authorizeURL() with %q = %q, expected %q", tt.redirectURL, got, tt.want)
+		}
+	}
+}
@@ -1,15 +1,14 @@
 package auth
 
 import (
-	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
-	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
+	"net/url"
+	"strings"
 	"time"
 
 	"golang.org/x/oauth2"
@@ -22,6 +21,8 @@ type Token struct {
 	RefreshToken string    `json:"refresh_token"`
 	Expiry       time.Time `json:"expiry"`
 	UserID       string    `json:"user_id,omitempty"`
+	// Scope lists the granted scopes, separated by spaces as in the OAuth2 token response.
+	Scope string `json:"scope,omitempty"`
 }
 
 // TokenProvider is an interface for types that can provide an access token.
@@ -29,68 +30,22 @@ type TokenProvider interface {
 	Token() *oauth2.Token
 }
 
-// Authorizer implements the Authorizer interface for OAuth2.
-type Authorizer struct {
-	token *oauth2.Token
-}
-
-// NewAuthorizer creates a new Authorizer.
-func NewAuthorizer(token *oauth2.Token) *Authorizer {
-	return &Authorizer{token: token}
-}
-
-// Add adds the OAuth2 authorization to the request.
-func (a *Authorizer) Add(req *http.Request) {
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", a.token.AccessToken))
-}
-
-// Token returns the authorizer's token.
-func (a *Authorizer) Token() *oauth2.Token {
-	return a.token
-}
-
-// SaveToken saves the OAuth2 token with user ID to a file.
-func SaveToken(filePath string, token *oauth2.Token, userID string) error {
-	tokenData := Token{
-		AccessToken:  token.AccessToken,
-		TokenType:    token.TokenType,
-		RefreshToken: token.RefreshToken,
-		Expiry:       token.Expiry,
-		UserID:       userID,
-	}
-
-	data, err := json.MarshalIndent(tokenData, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal token: %v", err)
+// GrantedScopes returns the scopes granted with token, or nil if they are not known, as
+// for tokens saved before the scopes were recorded.
+func GrantedScopes(token *oauth2.Token) []string {
+	scope, _ := token.Extra("scope").(string)
+	if scope == "" {
+		return nil
 	}
-
-	if err := os.WriteFile(filePath, data, 0600); err != nil {
-		return fmt.Errorf("failed to write token file: %v", err)
-	}
-
-	return nil
+	return strings.Fields(scope)
 }
 
-// LoadToken loads the OAuth2 token and user ID from a file.
-func LoadToken(filePath string) (*oauth2.Token, string, error) {
-	data, err := os.ReadFile(filePath)
-	if err != nil {
-		return nil, "", fmt.Errorf("failed to read token file: %v", err)
-	}
-
-	var tokenData Token
-	if err := json.Unmarshal(data, &tokenData); err != nil {
-		return nil, "", fmt.Errorf("failed to parse token file: %v", err)
+// withScope returns token with its granted scopes set to scope.
+func withScope(token *oauth2.Token, scope string) *oauth2.Token {
+	if scope == "" {
+		return token
 	}
-
-	token := &oauth2.Token{
-		AccessToken:  tokenData.AccessToken,
-		TokenType:    tokenData.TokenType,
-		RefreshToken: tokenData.RefreshToken,
-		Expiry:       tokenData.Expiry,
-	}
-
-	return token, tokenData.UserID, nil
+	return token.WithExtra(map[string]interface{}{"scope": scope})
 }
 
 // GenerateCodeVerifier creates a code verifier for PKCE.
@@ -102,6 +57,16 @@ func GenerateCodeVerifier() (string, error) {
 	return base64.RawURLEncoding.EncodeToString(b), nil
 }
 
+// GenerateState creates a random OAuth2 state parameter, which ties a callback to the
+// authorization attempt that started it.
+func GenerateState() (string, error) {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", fmt.Errorf("failed to generate random bytes: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
 // GenerateCodeChallenge creates a code challenge from a code verifier.
 func GenerateCodeChallenge(verifier string) string {
 	hash := sha256.Sum256([]byte(verifier))
@@ -118,9 +83,43 @@ func GetAuthURL(config *oauth2.Config, state string, codeChallenge string) strin
 	return config.AuthCodeURL(state, opts...)
 }
 
+// RevokeToken revokes token at revokeURL, authenticating as the client of config.
+// tokenTypeHint is "access_token" or "refresh_token".
+func RevokeToken(config *oauth2.Config, revokeURL, token, tokenTypeHint string) error {
+	form := url.Values{
+		"token":           {token},
+		"token_type_hint": {tokenTypeHint},
+	}
+	if config.ClientSecret == "" {
+		form.Set("client_id", config.ClientID)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, revokeURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create revoke request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(config.ClientID), url.QueryEscape(config.ClientSecret))
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send revoke request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("failed to revoke %s (HTTP %d): %s", tokenTypeHint, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
 // ExchangeToken exchanges an authorization code for an OAuth2 token with PKCE.
 func ExchangeToken(config *oauth2.Config, code string, codeVerifier string) (*oauth2.Token, error) {
-	ctx := context.Background()
+	ctx := tokenContext()
 	opts := []oauth2.AuthCodeOption{
 		oauth2.SetAuthURLParam("code_verifier", codeVerifier),
 	}
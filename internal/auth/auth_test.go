@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/korjavin/tw2dynalist/internal/logger"
+	"golang.org/x/oauth2"
+)
+
+func TestTokenStore_Encrypted(t *testing.T) {
+	log := logger.New("DEBUG")
+	path := filepath.Join(t.TempDir(), "token.json")
+	token := &oauth2.Token{
+		AccessToken:  "secret_access_token",
+		RefreshToken: "secret_refresh_token",
+		TokenType:    "bearer",
+		Expiry:       time.Now().Add(time.Hour).Round(time.Second),
+	}
+
+	store := NewTokenStore(path, "correct horse", log)
+	if err := store.SaveToken(token, "42"); err != nil {
+		t.Fatalf("SaveToken() returned an error: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret_") {
+		t.Fatalf("Expected the token file to be encrypted, got %s", data)
+	}
+
+	loaded, userID, err := NewTokenStore(path, "correct horse", log).LoadToken()
+	if err != nil {
+		t.Fatalf("LoadToken() returned an error: %v", err)
+	}
+	if loaded.AccessToken != token.AccessToken || loaded.RefreshToken != token.RefreshToken || !loaded.Expiry.Equal(token.Expiry) || userID != "42" {
+		t.Errorf("Expected the saved token for user 42, got %+v for user %s", loaded, userID)
+	}
+
+	if _, _, err := NewTokenStore(path, "wrong", log).LoadToken(); err == nil {
+		t.Error("Expected an error loading with the wrong passphrase")
+	}
+	if _, _, err := NewTokenStore(path, "", log).LoadToken(); err == nil {
+		t.Error("Expected an error loading an encrypted file without passphrase")
+	}
+}
+
+func TestTokenStore_RejectsWeakKeyDerivation(t *testing.T) {
+	log := logger.New("DEBUG")
+	path := filepath.Join(t.TempDir(), "token.json")
+	if err := NewTokenStore(path, "correct horse", log).SaveToken(&oauth2.Token{AccessToken: "secret_access_token"}, "42"); err != nil {
+		t.Fatalf("SaveToken() returned an error: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		tamper func(encrypted *encryptedToken)
+	}{
+		{"too few iterations", func(encrypted *encryptedToken) { encrypted.Iterations = 1 }},
+		{"too many iterations", func(encrypted *encryptedToken) { encrypted.Iterations = 1 << 40 }},
+		{"empty salt", func(encrypted *encryptedToken) { encrypted.Salt = nil }},
+	}
+	for _, tt := range tests {
+		var encrypted encryptedToken
+		if err := json.Unmarshal(data, &encrypted); err != nil {
+			t.Fatal(err)
+		}
+		tt.tamper(&encrypted)
+		tampered, err := json.Marshal(encrypted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, tampered, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := NewTokenStore(path, "correct horse", log).LoadToken(); err == nil {
+			t.Errorf("Expected an error loading a token file with %s", tt.name)
+		}
+	}
+}
+
+func TestTokenStore_MigratesPlaintext(t *testing.T) {
+	log := logger.New("DEBUG")
+	path := filepath.Join(t.TempDir(), "token.json")
+	token := &oauth2.Token{AccessToken: "secret_access_token", RefreshToken: "secret_refresh_token"}
+
+	if err := NewTokenStore(path, "", log).SaveToken(token, "42"); err != nil {
+		t.Fatalf("SaveToken() returned an error: %v", err)
+	}
+
+	loaded, userID, err := NewTokenStore(path, "correct horse", log).LoadToken()
+	if err != nil {
+		t.Fatalf("LoadToken() of a plaintext file returned an error: %v", err)
+	}
+	if loaded.AccessToken != token.AccessToken || userID != "42" {
+		t.Errorf("Expected the plaintext token for user 42, got %+v for user %s", loaded, userID)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret_") {
+		t.Errorf("Expected the plaintext token file to be re-saved encrypted, got %s", data)
+	}
+	if _, _, err := NewTokenStore(path, "correct horse", log).LoadToken(); err != nil {
+		t.Errorf("LoadToken() of the migrated file returned an error: %v", err)
+	}
+}
+
+func TestTokenStore_Scopes(t *testing.T) {
+	log := logger.New("DEBUG")
+	path := filepath.Join(t.TempDir(), "token.json")
+	store := NewTokenStore(path, "", log)
+
+	token := withScope(&oauth2.Token{AccessToken: "access"}, "tweet.read bookmark.read")
+	if err := store.SaveToken(token, "42"); err != nil {
+		t.Fatalf("SaveToken() returned an error: %v", err)
+	}
+	loaded, _, err := store.LoadToken()
+	if err != nil {
+		t.Fatalf("LoadToken() returned an error: %v", err)
+	}
+	if scopes := GrantedScopes(loaded); len(scopes) != 2 || scopes[0] != "tweet.read" || scopes[1] != "bookmark.read" {
+		t.Errorf("Expected the saved scopes, got %v", scopes)
+	}
+
+	// Tokens saved before scopes were recorded have unknown scopes.
+	if err := os.WriteFile(path, []byte(`{"access_token":"access"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	loaded, _, err = store.LoadToken()
+	if err != nil {
+		t.Fatalf("LoadToken() returned an error: %v", err)
+	}
+	if scopes := GrantedScopes(loaded); scopes != nil {
+		t.Errorf("Expected unknown scopes, got %v", scopes)
+	}
+}
+
+func TestTokenManager_RefreshDoesNotBlockToken(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintln(w, `{"access_token":"new_access_token","refresh_token":"new_refresh_token","token_type":"bearer","expires_in":7200}`)
+	}))
+	defer server.Close()
+
+	log := logger.New("DEBUG")
+	config := &oauth2.Config{Endpoint: oauth2.Endpoint{TokenURL: server.URL}}
+	store := NewTokenStore(filepath.Join(t.TempDir(), "token.json"), "", log)
+	m := NewTokenManager(config, &oauth2.Token{AccessToken: "old_access_token", RefreshToken: "old_refresh_token"}, store, "user", log)
+
+	done := make(chan error)
+	go func() { done <- m.Refresh("old_access_token") }()
+
+	tokens := make(chan string)
+	go func() { tokens <- m.Token().AccessToken }()
+	select {
+	case token := <-tokens:
+		if token != "old_access_token" {
+			t.Errorf("Expected the old token during the refresh, got '%s'", token)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Token() blocked while the refresh was in flight")
+	}
+
+	// Hold up the save of the refreshed token: requests get the new token meanwhile.
+	m.saveMu.Lock()
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for m.Token().AccessToken != "new_access_token" {
+		if time.Now().After(deadline) {
+			m.saveMu.Unlock()
+			t.Fatal("Expected the refreshed token to be handed out while it is saved")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	m.saveMu.Unlock()
+
+	if err := <-done; err != nil {
+		t.Fatalf("Refresh() returned an error: %v", err)
+	}
+	saved, _, err := store.LoadToken()
+	if err != nil || saved.AccessToken != "new_access_token" {
+		t.Errorf("Expected the refreshed token to be saved, got %+v (%v)", saved, err)
+	}
+}
@@ -0,0 +1,231 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/korjavin/tw2dynalist/internal/logger"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// tokenEncryption identifies the format of encrypted token files.
+	tokenEncryption = "aes-256-gcm/pbkdf2-sha256"
+	// kdfIterations is the PBKDF2 work factor for deriving the key from the passphrase.
+	// Token files asking for fewer iterations, or for more than maxKDFIterations, which
+	// would stall loading the token, are rejected.
+	kdfIterations    = 600000
+	maxKDFIterations = 10 * kdfIterations
+	kdfSaltSize      = 16
+	aesKeySize       = 32
+)
+
+// encryptedToken is the file format of an encrypted token. The ciphertext holds a Token
+// encoded as JSON.
+type encryptedToken struct {
+	Encryption string `json:"encryption"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// TokenStore saves and loads the OAuth2 token file. With a passphrase the token is
+// encrypted with AES-GCM using a key derived from it; otherwise it is stored as plain JSON.
+type TokenStore struct {
+	path       string
+	passphrase string
+	logger     *logger.Logger
+
+	// mu guards the key derived for salt, which is reused across saves as deriving it is slow.
+	mu   sync.Mutex
+	salt []byte
+	key  []byte
+}
+
+// NewTokenStore creates a TokenStore for the file at path. An empty passphrase stores the
+// token unencrypted.
+func NewTokenStore(path, passphrase string, logger *logger.Logger) *TokenStore {
+	return &TokenStore{path: path, passphrase: passphrase, logger: logger}
+}
+
+// Path returns the path of the token file.
+func (s *TokenStore) Path() string {
+	return s.path
+}
+
+// Delete removes the token file, if it exists.
+func (s *TokenStore) Delete() error {
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove token file: %v", err)
+	}
+	return nil
+}
+
+// SaveToken saves the OAuth2 token with user ID to the file.
+func (s *TokenStore) SaveToken(token *oauth2.Token, userID string) error {
+	tokenData := Token{
+		AccessToken:  token.AccessToken,
+		TokenType:    token.TokenType,
+		RefreshToken: token.RefreshToken,
+		Expiry:       token.Expiry,
+		UserID:       userID,
+		Scope:        strings.Join(GrantedScopes(token), " "),
+	}
+
+	data, err := json.MarshalIndent(tokenData, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal token: %v", err)
+	}
+
+	if s.passphrase != "" {
+		data, err = s.encrypt(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := os.WriteFile(s.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write token file: %v", err)
+	}
+
+	return nil
+}
+
+// LoadToken loads the OAuth2 token and user ID from the file. Plaintext files written
+// before encryption was configured are still read, and saved again encrypted.
+func (s *TokenStore) LoadToken() (*oauth2.Token, string, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read token file: %v", err)
+	}
+
+	var encrypted encryptedToken
+	if err := json.Unmarshal(data, &encrypted); err != nil {
+		return nil, "", fmt.Errorf("failed to parse token file: %v", err)
+	}
+	isEncrypted := encrypted.Encryption != ""
+	if isEncrypted {
+		if s.passphrase == "" {
+			return nil, "", fmt.Errorf("token file is encrypted, but no encryption passphrase is configured")
+		}
+		data, err = s.decrypt(&encrypted)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	var tokenData Token
+	if err := json.Unmarshal(data, &tokenData); err != nil {
+		return nil, "", fmt.Errorf("failed to parse token file: %v", err)
+	}
+
+	token := withScope(&oauth2.Token{
+		AccessToken:  tokenData.AccessToken,
+		TokenType:    tokenData.TokenType,
+		RefreshToken: tokenData.RefreshToken,
+		Expiry:       tokenData.Expiry,
+	}, tokenData.Scope)
+
+	if !isEncrypted && s.passphrase != "" {
+		if err := s.SaveToken(token, tokenData.UserID); err != nil {
+			s.logger.Warn("Failed to encrypt plaintext token file: %v", err)
+		} else {
+			s.logger.Info("Migrated plaintext token file %s to encrypted storage", s.path)
+		}
+	}
+
+	return token, tokenData.UserID, nil
+}
+
+// encrypt seals plaintext into the encrypted token file format.
+func (s *TokenStore) encrypt(plaintext []byte) ([]byte, error) {
+	s.mu.Lock()
+	if s.key == nil {
+		s.salt = make([]byte, kdfSaltSize)
+		if _, err := io.ReadFull(rand.Reader, s.salt); err != nil {
+			s.mu.Unlock()
+			return nil, fmt.Errorf("failed to generate salt: %v", err)
+		}
+		s.key = pbkdf2.Key([]byte(s.passphrase), s.salt, kdfIterations, aesKeySize, sha256.New)
+	}
+	salt, key := s.salt, s.key
+	s.mu.Unlock()
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %v", err)
+	}
+
+	data, err := json.MarshalIndent(encryptedToken{
+		Encryption: tokenEncryption,
+		Iterations: kdfIterations,
+		Salt:       salt,
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, plaintext, nil),
+	}, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal encrypted token: %v", err)
+	}
+	return data, nil
+}
+
+// decrypt opens an encrypted token file, returning the token JSON.
+func (s *TokenStore) decrypt(encrypted *encryptedToken) ([]byte, error) {
+	if encrypted.Encryption != tokenEncryption {
+		return nil, fmt.Errorf("unsupported token file encryption %q", encrypted.Encryption)
+	}
+	if encrypted.Iterations < kdfIterations || encrypted.Iterations > maxKDFIterations {
+		return nil, fmt.Errorf("invalid key derivation iterations %d in token file, expected %d to %d", encrypted.Iterations, kdfIterations, maxKDFIterations)
+	}
+	if len(encrypted.Salt) < kdfSaltSize {
+		return nil, fmt.Errorf("invalid salt in token file, expected at least %d bytes", kdfSaltSize)
+	}
+
+	s.mu.Lock()
+	key := s.key
+	if key == nil || !bytes.Equal(s.salt, encrypted.Salt) {
+		key = pbkdf2.Key([]byte(s.passphrase), encrypted.Salt, encrypted.Iterations, aesKeySize, sha256.New)
+		s.salt, s.key = encrypted.Salt, key
+	}
+	s.mu.Unlock()
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(encrypted.Nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce in token file")
+	}
+	plaintext, err := gcm.Open(nil, encrypted.Nonce, encrypted.Ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt token file, check the encryption passphrase: %v", err)
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %v", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %v", err)
+	}
+	return gcm, nil
+}
@@ -0,0 +1,253 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/korjavin/tw2dynalist/internal/logger"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// refreshMargin is how long before its expiry a token is refreshed.
+	refreshMargin = 5 * time.Minute
+	// refreshRetryInterval is how long to wait after a failed background refresh.
+	refreshRetryInterval = time.Minute
+	// idleCheckInterval is how often a token without expiry or refresh token is looked at again.
+	idleCheckInterval = time.Hour
+	// tokenRequestTimeout bounds the requests to the token endpoint.
+	tokenRequestTimeout = 30 * time.Second
+)
+
+// ErrNoRefreshToken is returned by Refresh when the token cannot be refreshed.
+var ErrNoRefreshToken = errors.New("no refresh token available")
+
+// TokenManager hands out the current OAuth2 token and refreshes it shortly before it
+// expires. It implements the twitterv2.Authorizer interface, so every request made
+// through it carries the current token.
+type TokenManager struct {
+	// refreshMu serializes refreshes, so that mu is not held during the token request and
+	// requests keep getting the current token meanwhile.
+	refreshMu sync.Mutex
+	// saveMu serializes writes of the token file, which happen without holding mu, as
+	// encrypting the token takes a while.
+	saveMu    sync.Mutex
+	mu        sync.Mutex
+	config    *oauth2.Config
+	token     *oauth2.Token
+	store     *TokenStore
+	userID    string
+	logger    *logger.Logger
+	onRefresh func(token *oauth2.Token)
+	changed   chan struct{}
+	stop      chan struct{}
+	stopOnce  sync.Once
+}
+
+// NewTokenManager creates a TokenManager for token, which may be nil if the user has not
+// authorized the app yet. Refreshed tokens are saved to store.
+func NewTokenManager(config *oauth2.Config, token *oauth2.Token, store *TokenStore, userID string, logger *logger.Logger) *TokenManager {
+	if token == nil {
+		token = &oauth2.Token{}
+	}
+	return &TokenManager{
+		config:  config,
+		token:   token,
+		store:   store,
+		userID:  userID,
+		logger:  logger,
+		changed: make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+	}
+}
+
+// Add adds the current token to the request.
+func (m *TokenManager) Add(req *http.Request) {
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", m.Token().AccessToken))
+}
+
+// Token returns a copy of the current token.
+func (m *TokenManager) Token() *oauth2.Token {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	token := *m.token
+	return &token
+}
+
+// HasToken reports whether there is an access token to authorize requests with.
+func (m *TokenManager) HasToken() bool {
+	return m.Token().AccessToken != ""
+}
+
+// SetToken replaces the current token, for example after the user authorized the app
+// again. A nil token clears it.
+func (m *TokenManager) SetToken(token *oauth2.Token) {
+	if token == nil {
+		token = &oauth2.Token{}
+	}
+	m.mu.Lock()
+	m.token = token
+	m.mu.Unlock()
+
+	// Wake up the background refresh so it picks up the new expiry.
+	select {
+	case m.changed <- struct{}{}:
+	default:
+	}
+}
+
+// SetUserID sets the user ID that is saved along with refreshed tokens.
+func (m *TokenManager) SetUserID(userID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.userID = userID
+}
+
+// Forget clears the token and user ID, and deletes the token file.
+func (m *TokenManager) Forget() error {
+	m.mu.Lock()
+	m.userID = ""
+	m.mu.Unlock()
+	m.SetToken(nil)
+	return m.store.Delete()
+}
+
+// Save saves the current token and user ID to the token store. Requests keep getting the
+// current token while it is written.
+func (m *TokenManager) Save() error {
+	m.saveMu.Lock()
+	defer m.saveMu.Unlock()
+
+	// The token is read under saveMu, so that a save cannot overwrite a newer token.
+	m.mu.Lock()
+	token, userID := m.token, m.userID
+	m.mu.Unlock()
+	return m.store.SaveToken(token, userID)
+}
+
+// OnRefresh registers fn to be called with every refreshed token.
+func (m *TokenManager) OnRefresh(fn func(token *oauth2.Token)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onRefresh = fn
+}
+
+// Refresh exchanges the refresh token for a new token. rejected is the access token that
+// prompted the refresh; if the token has been replaced since, it is not refreshed again.
+// This keeps concurrent callers that all saw the same 401 from refreshing more than once.
+func (m *TokenManager) Refresh(rejected string) error {
+	m.refreshMu.Lock()
+	defer m.refreshMu.Unlock()
+
+	current := m.Token()
+	if current.AccessToken != rejected {
+		m.logger.Debug("Token was already refreshed, skipping refresh")
+		return nil
+	}
+	if current.RefreshToken == "" {
+		return ErrNoRefreshToken
+	}
+
+	m.logger.Info("Attempting to refresh token")
+	// Without an access token the token source always refreshes, even if the current
+	// token has not expired yet.
+	newToken, err := m.config.TokenSource(tokenContext(), &oauth2.Token{RefreshToken: current.RefreshToken}).Token()
+	if err != nil {
+		return fmt.Errorf("failed to refresh token: %w", err)
+	}
+	if GrantedScopes(newToken) == nil {
+		// Keep the scopes known if the refresh response leaves them out.
+		newToken = withScope(newToken, strings.Join(GrantedScopes(current), " "))
+	}
+
+	m.mu.Lock()
+	if m.token.AccessToken != current.AccessToken {
+		// The user authorized again or logged out during the refresh.
+		m.mu.Unlock()
+		m.logger.Info("Token was replaced during the refresh, discarding the refreshed token")
+		return nil
+	}
+	m.logger.Info("Token refreshed successfully, valid until %s", newToken.Expiry.Format(time.RFC3339))
+	m.token = newToken
+	onRefresh := m.onRefresh
+	m.mu.Unlock()
+
+	if err := m.Save(); err != nil {
+		m.logger.Warn("Failed to save refreshed token: %v", err)
+	}
+	if onRefresh != nil {
+		onRefresh(newToken)
+	}
+	return nil
+}
+
+// tokenContext returns the context for requests to the token endpoint, which makes them
+// time out after tokenRequestTimeout.
+func tokenContext() context.Context {
+	return context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Timeout: tokenRequestTimeout})
+}
+
+// Start refreshes the token right away if it is about to expire, then keeps refreshing it
+// in the background until Stop is called.
+func (m *TokenManager) Start() {
+	if m.HasToken() && m.untilRefresh() == 0 {
+		if err := m.Refresh(m.Token().AccessToken); err != nil {
+			m.logger.Warn("Failed to refresh expiring token: %v", err)
+		}
+	}
+	go m.run()
+}
+
+// Stop ends background refreshing.
+func (m *TokenManager) Stop() {
+	m.stopOnce.Do(func() { close(m.stop) })
+}
+
+func (m *TokenManager) run() {
+	var failed bool
+	for {
+		wait := m.untilRefresh()
+		if failed && wait < refreshRetryInterval {
+			wait = refreshRetryInterval
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+			if m.untilRefresh() > 0 {
+				failed = false
+				continue
+			}
+			err := m.Refresh(m.Token().AccessToken)
+			if err != nil {
+				m.logger.Error("Background token refresh failed: %v", err)
+			}
+			failed = err != nil
+		case <-m.changed:
+			timer.Stop()
+			failed = false
+		case <-m.stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// untilRefresh returns how long until the token should be refreshed, which is zero if it
+// is due now.
+func (m *TokenManager) untilRefresh() time.Duration {
+	token := m.Token()
+	if token.Expiry.IsZero() || token.RefreshToken == "" {
+		return idleCheckInterval
+	}
+	wait := time.Until(token.Expiry.Add(-refreshMargin))
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
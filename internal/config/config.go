@@ -2,36 +2,134 @@ package config
 
 import (
 	"fmt"
+	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"time"
 )
 
+// DynalistDestination is a location in a Dynalist document that items are added under.
+type DynalistDestination struct {
+	FileID string
+	NodeID string
+}
+
+// Source types for SourceConfig.
+const (
+	SourceTypeList   = "list"
+	SourceTypeSearch = "search"
+)
+
+// SourceConfig configures an X List or recent-search query that is polled on its own schedule.
+type SourceConfig struct {
+	Type     string
+	Target   string
+	Interval time.Duration
+	// Keywords, if any, restrict saved tweets to those containing at least one of them.
+	Keywords []string
+}
+
+// Name returns a stable identifier for the source, used for its cache namespace.
+func (s SourceConfig) Name() string {
+	return s.Type + ":" + s.Target
+}
+
+// Account is a Twitter account processed by the app, with its own token, cache and
+// destinations. Without ACCOUNTS there is a single account with an empty name.
+type Account struct {
+	Name            string
+	TwitterUsername string
+	DynalistToken   string
+	TokenFilePath   string
+	CacheFilePath   string
+	NtfyTopic       string
+	// FolderDestinations and Sources are the account's BOOKMARK_FOLDERS, and LIST_SOURCES
+	// and SEARCH_SOURCES.
+	FolderDestinations map[string]DynalistDestination
+	Sources            []SourceConfig
+}
+
+// accountNamePattern restricts account names to what can be used in environment
+// variable names and file names.
+var accountNamePattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
 // Config holds all configuration for the application.
 type Config struct {
-	DynalistToken             string
-	TwitterClientID           string
-	TwitterClientSecret       string
-	TwitterRedirectURL        string
-	TwitterUsername           string
-	CacheFilePath             string
-	TokenFilePath             string
+	DynalistToken       string
+	TwitterClientID     string
+	TwitterClientSecret string
+	TwitterRedirectURL  string
+	TwitterUsername     string
+	CacheFilePath       string
+	TokenFilePath       string
+	// TokenEncryptionKey is the passphrase the token file is encrypted with; empty
+	// stores the token unencrypted.
+	TokenEncryptionKey        string
 	CheckInterval             time.Duration
 	LogLevel                  string
 	RemoveBookmarks           bool
 	CleanupProcessedBookmarks bool
+	UnrollThreads             bool
+	FolderDestinations        map[string]DynalistDestination
+	SyncLikes                 bool
+	Sources                   []SourceConfig
 	CallbackPort              string
 	NtfyServer                string
 	NtfyTopic                 string
 	NtfyUsername              string
 	NtfyPassword              string
+	// TwitterAPIURL is the root of the X API, such as https://api.x.com.
+	TwitterAPIURL string
+	// TwitterAuthURL and TwitterTokenURL are the OAuth 2.0 authorization and token
+	// endpoints, and TwitterRevokeURL revokes tokens.
+	TwitterAuthURL   string
+	TwitterTokenURL  string
+	TwitterRevokeURL string
+	// DynalistAPIURL is the root of the Dynalist API, such as https://dynalist.io/api/v1.
+	DynalistAPIURL string
+	// Accounts lists the configured accounts, at least one.
+	Accounts []Account
+	// AccountName is the account a configuration returned by ForAccount belongs to.
+	AccountName string
+}
+
+// ForAccount returns a copy of the configuration with the account's token, cache,
+// destinations and sources in place of the shared ones.
+func (c *Config) ForAccount(account Account) *Config {
+	cfg := *c
+	cfg.AccountName = account.Name
+	cfg.TwitterUsername = account.TwitterUsername
+	cfg.DynalistToken = account.DynalistToken
+	cfg.TokenFilePath = account.TokenFilePath
+	cfg.CacheFilePath = account.CacheFilePath
+	cfg.NtfyTopic = account.NtfyTopic
+	cfg.FolderDestinations = account.FolderDestinations
+	cfg.Sources = account.Sources
+	return &cfg
+}
+
+// FindAccount returns the account called name. An empty name selects the only account,
+// and is an error if there are several.
+func (c *Config) FindAccount(name string) (Account, error) {
+	var names []string
+	for _, account := range c.Accounts {
+		if account.Name == name || (name == "" && len(c.Accounts) == 1) {
+			return account, nil
+		}
+		names = append(names, account.Name)
+	}
+	if name == "" {
+		return Account{}, fmt.Errorf("several accounts are configured, name one of: %s", strings.Join(names, ", "))
+	}
+	return Account{}, fmt.Errorf("unknown account %q, configured accounts: %s", name, strings.Join(names, ", "))
 }
 
 // Load reads configuration from environment variables and returns a Config struct.
 func Load() (*Config, error) {
+	// Accounts may each have their own Dynalist token, so it is checked per account below.
 	dynalistToken := os.Getenv("DYNALIST_TOKEN")
-	if dynalistToken == "" {
-		return nil, fmt.Errorf("DYNALIST_TOKEN environment variable is required")
-	}
 
 	twitterClientID := os.Getenv("TWITTER_CLIENT_ID")
 	if twitterClientID == "" {
@@ -48,10 +146,8 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("TWITTER_REDIRECT_URL environment variable is required")
 	}
 
+	// The user is resolved from the token; TW_USER is only checked against it.
 	twitterUsername := os.Getenv("TW_USER")
-	if twitterUsername == "" {
-		return nil, fmt.Errorf("TW_USER environment variable is required")
-	}
 
 	cacheFilePath := os.Getenv("CACHE_FILE_PATH")
 	if cacheFilePath == "" {
@@ -63,6 +159,11 @@ func Load() (*Config, error) {
 		tokenFilePath = "token.json"
 	}
 
+	tokenEncryptionKey, err := loadTokenEncryptionKey(os.Getenv("TOKEN_ENCRYPTION_PASSPHRASE"), os.Getenv("TOKEN_ENCRYPTION_KEY_FILE"))
+	if err != nil {
+		return nil, err
+	}
+
 	checkIntervalStr := os.Getenv("CHECK_INTERVAL")
 	var checkInterval time.Duration
 	if checkIntervalStr == "" {
@@ -86,6 +187,26 @@ func Load() (*Config, error) {
 	cleanupProcessedBookmarksStr := os.Getenv("CLEANUP_PROCESSED_BOOKMARKS")
 	cleanupProcessedBookmarks := cleanupProcessedBookmarksStr == "true"
 
+	// Threads are unrolled unless explicitly disabled.
+	unrollThreads := os.Getenv("UNROLL_THREADS") != "false"
+
+	folderDestinations, err := parseFolderDestinations(os.Getenv("BOOKMARK_FOLDERS"))
+	if err != nil {
+		return nil, err
+	}
+
+	syncLikesStr := os.Getenv("SYNC_LIKES")
+	syncLikes := syncLikesStr == "true"
+
+	listSources, err := parseSources(SourceTypeList, os.Getenv("LIST_SOURCES"), checkInterval)
+	if err != nil {
+		return nil, err
+	}
+	searchSources, err := parseSources(SourceTypeSearch, os.Getenv("SEARCH_SOURCES"), checkInterval)
+	if err != nil {
+		return nil, err
+	}
+
 	callbackPort := os.Getenv("CALLBACK_PORT")
 	if callbackPort == "" {
 		callbackPort = "8080"
@@ -103,6 +224,41 @@ func Load() (*Config, error) {
 	ntfyUsername := os.Getenv("NTFY_USERNAME")
 	ntfyPassword := os.Getenv("NTFY_PASSWORD")
 
+	twitterAPIURL, err := parseBaseURL("TWITTER_API_URL", "https://api.twitter.com")
+	if err != nil {
+		return nil, err
+	}
+	twitterAuthURL, err := parseBaseURL("TWITTER_AUTH_URL", "https://twitter.com/i/oauth2/authorize")
+	if err != nil {
+		return nil, err
+	}
+	// The token endpoints live on the API host unless configured otherwise.
+	twitterTokenURL, err := parseBaseURL("TWITTER_TOKEN_URL", twitterAPIURL+"/2/oauth2/token")
+	if err != nil {
+		return nil, err
+	}
+	twitterRevokeURL, err := parseBaseURL("TWITTER_REVOKE_URL", twitterAPIURL+"/2/oauth2/revoke")
+	if err != nil {
+		return nil, err
+	}
+	dynalistAPIURL, err := parseBaseURL("DYNALIST_API_URL", "https://dynalist.io/api/v1")
+	if err != nil {
+		return nil, err
+	}
+
+	accounts, err := parseAccounts(os.Getenv("ACCOUNTS"), Account{
+		TwitterUsername:    twitterUsername,
+		DynalistToken:      dynalistToken,
+		TokenFilePath:      tokenFilePath,
+		CacheFilePath:      cacheFilePath,
+		NtfyTopic:          ntfyTopic,
+		FolderDestinations: folderDestinations,
+		Sources:            append(listSources, searchSources...),
+	}, checkInterval, os.Getenv)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Config{
 		DynalistToken:             dynalistToken,
 		TwitterClientID:           twitterClientID,
@@ -111,14 +267,240 @@ func Load() (*Config, error) {
 		TwitterUsername:           twitterUsername,
 		CacheFilePath:             cacheFilePath,
 		TokenFilePath:             tokenFilePath,
+		TokenEncryptionKey:        tokenEncryptionKey,
 		CheckInterval:             checkInterval,
 		LogLevel:                  logLevel,
 		RemoveBookmarks:           removeBookmarks,
 		CleanupProcessedBookmarks: cleanupProcessedBookmarks,
+		UnrollThreads:             unrollThreads,
+		FolderDestinations:        folderDestinations,
+		SyncLikes:                 syncLikes,
+		Sources:                   append(listSources, searchSources...),
 		CallbackPort:              callbackPort,
 		NtfyServer:                ntfyServer,
 		NtfyTopic:                 ntfyTopic,
 		NtfyUsername:              ntfyUsername,
 		NtfyPassword:              ntfyPassword,
+		TwitterAPIURL:             twitterAPIURL,
+		TwitterAuthURL:            twitterAuthURL,
+		TwitterTokenURL:           twitterTokenURL,
+		TwitterRevokeURL:          twitterRevokeURL,
+		DynalistAPIURL:            dynalistAPIURL,
+		Accounts:                  accounts,
 	}, nil
 }
+
+// parseBaseURL reads the URL in the environment variable name, or returns defaultURL if it
+// is not set. The URL must be absolute, and is returned without a trailing slash.
+func parseBaseURL(name, defaultURL string) (string, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultURL, nil
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid %s %q, expected an http or https URL", name, value)
+	}
+	return strings.TrimSuffix(value, "/"), nil
+}
+
+// parseAccounts parses the comma-separated ACCOUNTS list. Each account is configured with
+// ACCOUNT_<NAME>_ variables, looked up with getenv, and otherwise derived from defaults:
+// the token and cache files get the account name inserted before their extension, and the
+// ntfy topic gets it appended. Bookmark folders and sources are taken over as they are,
+// but only by accounts sharing a Dynalist token, as the folder destinations name documents
+// of one Dynalist account. Without names, defaults is the only account.
+func parseAccounts(names string, defaults Account, checkInterval time.Duration, getenv func(string) string) ([]Account, error) {
+	var accounts []Account
+	// seen holds the variable prefixes and file paths in use, which accounts must not share.
+	seen := make(map[string]bool)
+	// inherited holds the Dynalist tokens of the accounts using each shared setting.
+	inherited := make(map[string]map[string]bool)
+	inherit := func(variable, token string) {
+		if inherited[variable] == nil {
+			inherited[variable] = make(map[string]bool)
+		}
+		inherited[variable][token] = true
+	}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !accountNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid account name %q in ACCOUNTS, use letters, digits and underscores", name)
+		}
+		prefix := "ACCOUNT_" + strings.ToUpper(name) + "_"
+		if seen[prefix] {
+			return nil, fmt.Errorf("account %q is listed more than once in ACCOUNTS", name)
+		}
+		seen[prefix] = true
+
+		account := Account{
+			Name:            name,
+			TwitterUsername: getenv(prefix + "TW_USER"),
+			DynalistToken:   getenv(prefix + "DYNALIST_TOKEN"),
+			TokenFilePath:   getenv(prefix + "TOKEN_FILE_PATH"),
+			CacheFilePath:   getenv(prefix + "CACHE_FILE_PATH"),
+			NtfyTopic:       getenv(prefix + "NTFY_TOPIC"),
+		}
+		if account.DynalistToken == "" {
+			account.DynalistToken = defaults.DynalistToken
+		}
+		if account.DynalistToken == "" {
+			return nil, fmt.Errorf("%sDYNALIST_TOKEN or DYNALIST_TOKEN environment variable is required", prefix)
+		}
+		if account.TokenFilePath == "" {
+			account.TokenFilePath = accountFilePath(defaults.TokenFilePath, name)
+		}
+		if account.CacheFilePath == "" {
+			account.CacheFilePath = accountFilePath(defaults.CacheFilePath, name)
+		}
+		if account.NtfyTopic == "" {
+			account.NtfyTopic = defaults.NtfyTopic + "-" + name
+		}
+
+		if value := getenv(prefix + "BOOKMARK_FOLDERS"); value != "" {
+			destinations, err := parseFolderDestinations(value)
+			if err != nil {
+				return nil, fmt.Errorf("%sBOOKMARK_FOLDERS: %v", prefix, err)
+			}
+			account.FolderDestinations = destinations
+		} else {
+			account.FolderDestinations = defaults.FolderDestinations
+			if len(defaults.FolderDestinations) > 0 {
+				inherit("BOOKMARK_FOLDERS", account.DynalistToken)
+			}
+		}
+		for _, sourceType := range []string{SourceTypeList, SourceTypeSearch} {
+			variable := strings.ToUpper(sourceType) + "_SOURCES"
+			if value := getenv(prefix + variable); value != "" {
+				sources, err := parseSources(sourceType, value, checkInterval)
+				if err != nil {
+					return nil, fmt.Errorf("%s%s: %v", prefix, variable, err)
+				}
+				account.Sources = append(account.Sources, sources...)
+				continue
+			}
+			for _, source := range defaults.Sources {
+				if source.Type == sourceType {
+					account.Sources = append(account.Sources, source)
+					inherit(variable, account.DynalistToken)
+				}
+			}
+		}
+
+		for _, path := range []string{account.TokenFilePath, account.CacheFilePath} {
+			if seen[path] {
+				return nil, fmt.Errorf("account %q uses %s, which another account already uses", name, path)
+			}
+			seen[path] = true
+		}
+		accounts = append(accounts, account)
+	}
+
+	for _, variable := range []string{"BOOKMARK_FOLDERS", "LIST_SOURCES", "SEARCH_SOURCES"} {
+		if len(inherited[variable]) > 1 {
+			return nil, fmt.Errorf("%s is shared by accounts with different Dynalist tokens, set ACCOUNT_<NAME>_%s for each account instead", variable, variable)
+		}
+	}
+
+	if len(accounts) > 0 {
+		return accounts, nil
+	}
+	if defaults.DynalistToken == "" {
+		return nil, fmt.Errorf("DYNALIST_TOKEN environment variable is required")
+	}
+	return []Account{defaults}, nil
+}
+
+// accountFilePath inserts the account name before the extension of path, so that
+// data/token.json becomes data/token.alice.json.
+func accountFilePath(path, name string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + name + ext
+}
+
+// loadTokenEncryptionKey returns the token encryption passphrase, given either directly or
+// as the path of a file containing it.
+func loadTokenEncryptionKey(passphrase, keyFile string) (string, error) {
+	if passphrase != "" && keyFile != "" {
+		return "", fmt.Errorf("only one of TOKEN_ENCRYPTION_PASSPHRASE and TOKEN_ENCRYPTION_KEY_FILE may be set")
+	}
+	if keyFile == "" {
+		return passphrase, nil
+	}
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read TOKEN_ENCRYPTION_KEY_FILE: %v", err)
+	}
+	key := strings.TrimSpace(string(data))
+	if key == "" {
+		return "", fmt.Errorf("TOKEN_ENCRYPTION_KEY_FILE %s is empty", keyFile)
+	}
+	return key, nil
+}
+
+// parseFolderDestinations parses a comma-separated list of folder=file_id[:node_id] entries.
+// Without a node ID, items are added to the root of the document.
+func parseFolderDestinations(value string) (map[string]DynalistDestination, error) {
+	destinations := make(map[string]DynalistDestination)
+	if strings.TrimSpace(value) == "" {
+		return destinations, nil
+	}
+
+	for _, entry := range strings.Split(value, ",") {
+		folder, target, ok := strings.Cut(entry, "=")
+		folder = strings.TrimSpace(folder)
+		target = strings.TrimSpace(target)
+		if !ok || folder == "" || target == "" {
+			return nil, fmt.Errorf("invalid BOOKMARK_FOLDERS entry %q, expected folder=file_id[:node_id]", entry)
+		}
+
+		fileID, nodeID, _ := strings.Cut(target, ":")
+		if nodeID == "" {
+			nodeID = "root"
+		}
+		destinations[folder] = DynalistDestination{FileID: fileID, NodeID: nodeID}
+	}
+	return destinations, nil
+}
+
+// parseSources parses a semicolon-separated list of target|interval|keywords entries, where
+// interval defaults to defaultInterval and keywords is a comma-separated list.
+func parseSources(sourceType, value string, defaultInterval time.Duration) ([]SourceConfig, error) {
+	var sources []SourceConfig
+	for _, entry := range strings.Split(value, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "|", 3)
+		source := SourceConfig{
+			Type:     sourceType,
+			Target:   strings.TrimSpace(parts[0]),
+			Interval: defaultInterval,
+		}
+		if source.Target == "" {
+			return nil, fmt.Errorf("invalid %s source %q, expected target|interval|keywords", sourceType, entry)
+		}
+		if len(parts) > 1 && strings.TrimSpace(parts[1]) != "" {
+			interval, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid interval for %s source %q: %v", sourceType, source.Target, err)
+			}
+			if interval <= 0 {
+				return nil, fmt.Errorf("invalid interval for %s source %q: must be positive, got %s", sourceType, source.Target, interval)
+			}
+			source.Interval = interval
+		}
+		if len(parts) > 2 {
+			for _, keyword := range strings.Split(parts[2], ",") {
+				if keyword = strings.TrimSpace(keyword); keyword != "" {
+					source.Keywords = append(source.Keywords, keyword)
+				}
+			}
+		}
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
@@ -2,6 +2,9 @@ package config
 
 import (
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -78,4 +81,201 @@ func TestLoad(t *testing.T) {
 	if cfg.CallbackPort != "8888" {
 		t.Errorf("expected CallbackPort to be '8888', got '%s'", cfg.CallbackPort)
 	}
+	if cfg.TwitterTokenURL != "https://api.twitter.com/2/oauth2/token" {
+		t.Errorf("expected TwitterTokenURL to default to the API host, got '%s'", cfg.TwitterTokenURL)
+	}
+	if cfg.DynalistAPIURL != "https://dynalist.io/api/v1" {
+		t.Errorf("expected DynalistAPIURL to be 'https://dynalist.io/api/v1', got '%s'", cfg.DynalistAPIURL)
+	}
+}
+
+func TestParseFolderDestinations(t *testing.T) {
+	destinations, err := parseFolderDestinations("papers=file1, read later = file2:node2")
+	if err != nil {
+		t.Fatalf("parseFolderDestinations() returned an error: %v", err)
+	}
+	if len(destinations) != 2 {
+		t.Fatalf("expected 2 destinations, got %d", len(destinations))
+	}
+	if d := destinations["papers"]; d.FileID != "file1" || d.NodeID != "root" {
+		t.Errorf("expected papers to map to file1/root, got %s/%s", d.FileID, d.NodeID)
+	}
+	if d := destinations["read later"]; d.FileID != "file2" || d.NodeID != "node2" {
+		t.Errorf("expected 'read later' to map to file2/node2, got %s/%s", d.FileID, d.NodeID)
+	}
+
+	if _, err := parseFolderDestinations("papers"); err == nil {
+		t.Error("expected an error for an entry without a destination")
+	}
+}
+
+func TestParseSources(t *testing.T) {
+	sources, err := parseSources(SourceTypeSearch, "from:golang release|6h; #gophercon||talk, slides ;", time.Hour)
+	if err != nil {
+		t.Fatalf("parseSources() returned an error: %v", err)
+	}
+	if len(sources) != 2 {
+		t.Fatalf("expected 2 sources, got %d", len(sources))
+	}
+	if sources[0].Target != "from:golang release" || sources[0].Interval != 6*time.Hour || len(sources[0].Keywords) != 0 {
+		t.Errorf("unexpected first source: %+v", sources[0])
+	}
+	if sources[1].Target != "#gophercon" || sources[1].Interval != time.Hour {
+		t.Errorf("unexpected second source: %+v", sources[1])
+	}
+	if len(sources[1].Keywords) != 2 || sources[1].Keywords[0] != "talk" || sources[1].Keywords[1] != "slides" {
+		t.Errorf("expected keywords [talk slides], got %v", sources[1].Keywords)
+	}
+	if sources[1].Name() != "search:#gophercon" {
+		t.Errorf("expected name 'search:#gophercon', got '%s'", sources[1].Name())
+	}
+
+	if _, err := parseSources(SourceTypeList, "123|often", time.Hour); err == nil {
+		t.Error("expected an error for an invalid interval")
+	}
+	for _, value := range []string{"123|0s", "123|-5m"} {
+		if _, err := parseSources(SourceTypeList, value, time.Hour); err == nil {
+			t.Errorf("expected an error for the non-positive interval in %q", value)
+		}
+	}
+}
+
+func TestParseBaseURL(t *testing.T) {
+	os.Unsetenv("TEST_API_URL")
+	if u, err := parseBaseURL("TEST_API_URL", "https://api.twitter.com"); err != nil || u != "https://api.twitter.com" {
+		t.Errorf("Expected the default URL, got %q (%v)", u, err)
+	}
+
+	os.Setenv("TEST_API_URL", "http://localhost:9000/mock/")
+	defer os.Unsetenv("TEST_API_URL")
+	if u, err := parseBaseURL("TEST_API_URL", "https://api.twitter.com"); err != nil || u != "http://localhost:9000/mock" {
+		t.Errorf("Expected the configured URL without a trailing slash, got %q (%v)", u, err)
+	}
+
+	for _, invalid := range []string{"api.x.com", "ftp://api.x.com", "https://"} {
+		os.Setenv("TEST_API_URL", invalid)
+		if _, err := parseBaseURL("TEST_API_URL", "https://api.twitter.com"); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestLoadTokenEncryptionKey(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "token.key")
+	if err := os.WriteFile(keyFile, []byte("file passphrase\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if key, err := loadTokenEncryptionKey("", ""); err != nil || key != "" {
+		t.Errorf("Expected no key by default, got %q (%v)", key, err)
+	}
+	if key, err := loadTokenEncryptionKey("env passphrase", ""); err != nil || key != "env passphrase" {
+		t.Errorf("Expected the passphrase, got %q (%v)", key, err)
+	}
+	if key, err := loadTokenEncryptionKey("", keyFile); err != nil || key != "file passphrase" {
+		t.Errorf("Expected the key file contents, got %q (%v)", key, err)
+	}
+	if _, err := loadTokenEncryptionKey("env passphrase", keyFile); err == nil {
+		t.Error("Expected an error when both a passphrase and a key file are set")
+	}
+	if _, err := loadTokenEncryptionKey("", filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("Expected an error for a missing key file")
+	}
+}
+
+func TestParseAccounts(t *testing.T) {
+	defaults := Account{
+		TwitterUsername: "shared_user",
+		DynalistToken:   "shared_token",
+		TokenFilePath:   "data/token.json",
+		CacheFilePath:   "data/cache.json",
+		NtfyTopic:       "tw2dynalist",
+		FolderDestinations: map[string]DynalistDestination{
+			"Reading": {FileID: "shared_doc", NodeID: "root"},
+		},
+		Sources: []SourceConfig{
+			{Type: SourceTypeList, Target: "111", Interval: time.Hour},
+			{Type: SourceTypeSearch, Target: "golang", Interval: time.Hour},
+		},
+	}
+	env := map[string]string{
+		"ACCOUNT_BOB_DYNALIST_TOKEN":   "bob_token",
+		"ACCOUNT_BOB_NTFY_TOPIC":       "bob-topic",
+		"ACCOUNT_BOB_TW_USER":          "bob_on_x",
+		"ACCOUNT_BOB_BOOKMARK_FOLDERS": "Work=bob_doc",
+		"ACCOUNT_BOB_LIST_SOURCES":     "222|30m",
+		"ACCOUNT_BOB_SEARCH_SOURCES":   "rust",
+	}
+	getenv := func(key string) string { return env[key] }
+
+	accounts, err := parseAccounts("", defaults, time.Hour, getenv)
+	if err != nil {
+		t.Fatalf("parseAccounts() returned an error: %v", err)
+	}
+	if len(accounts) != 1 || !reflect.DeepEqual(accounts[0], defaults) {
+		t.Errorf("expected the defaults as the only account, got %+v", accounts)
+	}
+
+	accounts, err = parseAccounts("alice, bob", defaults, time.Hour, getenv)
+	if err != nil {
+		t.Fatalf("parseAccounts() returned an error: %v", err)
+	}
+	if len(accounts) != 2 {
+		t.Fatalf("expected 2 accounts, got %d", len(accounts))
+	}
+	alice := Account{Name: "alice", DynalistToken: "shared_token", TokenFilePath: "data/token.alice.json", CacheFilePath: "data/cache.alice.json", NtfyTopic: "tw2dynalist-alice",
+		FolderDestinations: defaults.FolderDestinations, Sources: defaults.Sources}
+	if !reflect.DeepEqual(accounts[0], alice) {
+		t.Errorf("expected %+v, got %+v", alice, accounts[0])
+	}
+	bob := Account{Name: "bob", TwitterUsername: "bob_on_x", DynalistToken: "bob_token", TokenFilePath: "data/token.bob.json", CacheFilePath: "data/cache.bob.json", NtfyTopic: "bob-topic",
+		FolderDestinations: map[string]DynalistDestination{"Work": {FileID: "bob_doc", NodeID: "root"}},
+		Sources: []SourceConfig{
+			{Type: SourceTypeList, Target: "222", Interval: 30 * time.Minute},
+			{Type: SourceTypeSearch, Target: "rust", Interval: time.Hour},
+		}}
+	if !reflect.DeepEqual(accounts[1], bob) {
+		t.Errorf("expected %+v, got %+v", bob, accounts[1])
+	}
+
+	// Without its own folders, bob would add items to a document of alice's Dynalist account.
+	delete(env, "ACCOUNT_BOB_BOOKMARK_FOLDERS")
+	if _, err := parseAccounts("alice,bob", defaults, time.Hour, getenv); err == nil || !strings.Contains(err.Error(), "BOOKMARK_FOLDERS") {
+		t.Errorf("expected an error for folders shared by different Dynalist tokens, got %v", err)
+	}
+	delete(env, "ACCOUNT_BOB_DYNALIST_TOKEN")
+	if _, err := parseAccounts("alice,bob", defaults, time.Hour, getenv); err != nil {
+		t.Errorf("expected accounts with the same Dynalist token to share folders, got %v", err)
+	}
+
+	if _, err := parseAccounts("alice,Alice", defaults, time.Hour, getenv); err == nil {
+		t.Error("expected an error for a repeated account")
+	}
+	if _, err := parseAccounts("alice-smith", defaults, time.Hour, getenv); err == nil {
+		t.Error("expected an error for an invalid account name")
+	}
+	if _, err := parseAccounts("alice", Account{TokenFilePath: "token.json"}, time.Hour, getenv); err == nil {
+		t.Error("expected an error for an account without a Dynalist token")
+	}
+	if _, err := parseAccounts("", Account{}, time.Hour, getenv); err == nil {
+		t.Error("expected an error without a Dynalist token")
+	}
+}
+
+func TestFindAccount(t *testing.T) {
+	single := &Config{Accounts: []Account{{TokenFilePath: "token.json"}}}
+	if account, err := single.FindAccount(""); err != nil || account.TokenFilePath != "token.json" {
+		t.Errorf("expected the only account, got %+v (%v)", account, err)
+	}
+
+	multi := &Config{Accounts: []Account{{Name: "alice"}, {Name: "bob"}}}
+	if account, err := multi.FindAccount("bob"); err != nil || account.Name != "bob" {
+		t.Errorf("expected account bob, got %+v (%v)", account, err)
+	}
+	if _, err := multi.FindAccount(""); err == nil {
+		t.Error("expected an error when no account is named among several")
+	}
+	if _, err := multi.FindAccount("carol"); err == nil {
+		t.Error("expected an error for an unknown account")
+	}
 }
@@ -6,22 +6,45 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/korjavin/tw2dynalist/internal/apierr"
 	"github.com/korjavin/tw2dynalist/internal/logger"
 )
 
+// rateLimitWindow is how long to wait after Dynalist reports a rate limit, which it does
+// not say when resets.
+const rateLimitWindow = time.Minute
+
 // Client defines the interface for interacting with the Dynalist API.
 type Client interface {
-	AddToInbox(content, note string) error
+	AddToInbox(content, note string) (*Node, error)
+	AddToDocument(fileID, parentID, content, note string) (*Node, error)
+	AddChildren(parent *Node, children []Item) error
+	Annotate(node *Node, annotation string) error
 }
 
 // APIClient implements the Client interface for the Dynalist API.
 type APIClient struct {
-	token   string
-	client  *http.Client
-	logger  *logger.Logger
-	BaseURL string
+	token      string
+	client     *http.Client
+	logger     *logger.Logger
+	BaseURL    string
+	DocEditURL string
+	DocReadURL string
+}
+
+// Node identifies an item that exists in a Dynalist document.
+type Node struct {
+	FileID string
+	NodeID string
+}
+
+// Item is the content and note of a node to be created.
+type Item struct {
+	Content string
+	Note    string
 }
 
 // InboxRequest represents the request to add an item to Dynalist inbox.
@@ -31,19 +54,45 @@ type InboxRequest struct {
 	Note    string `json:"note,omitempty"`
 }
 
-// NewClient creates a new Dynalist API client.
-func NewClient(token string, logger *logger.Logger) *APIClient {
-	logger.Debug("Creating new Dynalist client")
+// DocEditRequest represents a request to apply changes to a Dynalist document.
+type DocEditRequest struct {
+	Token   string          `json:"token"`
+	FileID  string          `json:"file_id"`
+	Changes []DocEditChange `json:"changes"`
+}
+
+// DocReadRequest represents a request to read the nodes of a Dynalist document.
+type DocReadRequest struct {
+	Token  string `json:"token"`
+	FileID string `json:"file_id"`
+}
+
+// DocEditChange is a single change within a DocEditRequest.
+type DocEditChange struct {
+	Action   string `json:"action"`
+	NodeID   string `json:"node_id,omitempty"`
+	ParentID string `json:"parent_id,omitempty"`
+	Index    int    `json:"index"`
+	Content  string `json:"content,omitempty"`
+	Note     string `json:"note,omitempty"`
+}
+
+// NewClient creates a new Dynalist API client for the API rooted at apiURL, such as
+// https://dynalist.io/api/v1.
+func NewClient(apiURL, token string, logger *logger.Logger) *APIClient {
+	logger.Debug("Creating new Dynalist client for %s", apiURL)
 	return &APIClient{
-		token:   token,
-		client:  &http.Client{Timeout: 10 * time.Second},
-		logger:  logger,
-		BaseURL: "https://dynalist.io/api/v1/inbox/add",
+		token:      token,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		logger:     logger,
+		BaseURL:    apiURL + "/inbox/add",
+		DocEditURL: apiURL + "/doc/edit",
+		DocReadURL: apiURL + "/doc/read",
 	}
 }
 
-// AddToInbox adds an item to the Dynalist inbox.
-func (c *APIClient) AddToInbox(content, note string) error {
+// AddToInbox adds an item to the Dynalist inbox and returns the created node.
+func (c *APIClient) AddToInbox(content, note string) (*Node, error) {
 	c.logger.Debug("Preparing request to add item to Dynalist inbox")
 	reqBody := InboxRequest{
 		Token:   c.token,
@@ -51,32 +100,158 @@ func (c *APIClient) AddToInbox(content, note string) error {
 		Note:    note,
 	}
 
+	result, err := c.post(c.BaseURL, reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	node := &Node{}
+	node.FileID, _ = result["file_id"].(string)
+	node.NodeID, _ = result["node_id"].(string)
+
+	c.logger.Debug("Successfully added item to Dynalist inbox")
+	return node, nil
+}
+
+// AddToDocument appends an item under the given parent node of a document and returns the created node.
+func (c *APIClient) AddToDocument(fileID, parentID, content, note string) (*Node, error) {
+	c.logger.Debug("Preparing request to add item to Dynalist document %s", fileID)
+	reqBody := DocEditRequest{
+		Token:  c.token,
+		FileID: fileID,
+		Changes: []DocEditChange{{
+			Action:   "insert",
+			ParentID: parentID,
+			Index:    -1,
+			Content:  content,
+			Note:     note,
+		}},
+	}
+
+	result, err := c.post(c.DocEditURL, reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	node := &Node{FileID: fileID}
+	if ids, ok := result["new_node_ids"].([]interface{}); ok && len(ids) > 0 {
+		node.NodeID, _ = ids[0].(string)
+	}
+
+	c.logger.Debug("Successfully added item to Dynalist document %s", fileID)
+	return node, nil
+}
+
+// AddChildren appends the given items, in order, as children of the parent node.
+func (c *APIClient) AddChildren(parent *Node, children []Item) error {
+	if len(children) == 0 {
+		return nil
+	}
+	if parent == nil || parent.FileID == "" || parent.NodeID == "" {
+		return fmt.Errorf("parent node is unknown, cannot add children")
+	}
+
+	c.logger.Debug("Preparing request to add %d children to node %s", len(children), parent.NodeID)
+	reqBody := DocEditRequest{
+		Token:  c.token,
+		FileID: parent.FileID,
+	}
+	for i, child := range children {
+		reqBody.Changes = append(reqBody.Changes, DocEditChange{
+			Action:   "insert",
+			ParentID: parent.NodeID,
+			Index:    i,
+			Content:  child.Content,
+			Note:     child.Note,
+		})
+	}
+
+	if _, err := c.post(c.DocEditURL, reqBody); err != nil {
+		return err
+	}
+
+	c.logger.Debug("Successfully added %d children to node %s", len(children), parent.NodeID)
+	return nil
+}
+
+// Annotate appends annotation to the content of an existing node, unless it already ends
+// with it. The node's current content is read from its document first.
+func (c *APIClient) Annotate(node *Node, annotation string) error {
+	if node == nil || node.FileID == "" || node.NodeID == "" {
+		return fmt.Errorf("node is unknown, cannot annotate it")
+	}
+
+	c.logger.Debug("Reading Dynalist document %s to annotate node %s", node.FileID, node.NodeID)
+	result, err := c.post(c.DocReadURL, DocReadRequest{Token: c.token, FileID: node.FileID})
+	if err != nil {
+		return err
+	}
+
+	content, found := "", false
+	nodes, _ := result["nodes"].([]interface{})
+	for _, n := range nodes {
+		fields, _ := n.(map[string]interface{})
+		if id, _ := fields["id"].(string); id == node.NodeID {
+			content, _ = fields["content"].(string)
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("dynalist node %s %w in document %s", node.NodeID, apierr.ErrNotFound, node.FileID)
+	}
+	if strings.HasSuffix(content, annotation) {
+		return nil
+	}
+
+	reqBody := DocEditRequest{
+		Token:  c.token,
+		FileID: node.FileID,
+		Changes: []DocEditChange{{
+			Action:  "edit",
+			NodeID:  node.NodeID,
+			Content: content + " " + annotation,
+		}},
+	}
+	if _, err := c.post(c.DocEditURL, reqBody); err != nil {
+		return err
+	}
+
+	c.logger.Debug("Annotated node %s with %q", node.NodeID, annotation)
+	return nil
+}
+
+// post sends a request to the Dynalist API and returns the decoded response.
+func (c *APIClient) post(url string, reqBody interface{}) (map[string]interface{}, error) {
 	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
-		return fmt.Errorf("failed to marshal request: %v", err)
+		return nil, fmt.Errorf("failed to marshal request: %v", err)
 	}
 
-	c.logger.Debug("Sending request to Dynalist API at %s", c.BaseURL)
-	req, err := http.NewRequest("POST", c.BaseURL, bytes.NewBuffer(jsonData))
+	c.logger.Debug("Sending request to Dynalist API at %s", url)
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
-		return fmt.Errorf("failed to create request: %v", err)
+		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to send request: %v", err)
+		return nil, fmt.Errorf("failed to send request: %w", apierr.Transient(err))
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("failed to read response: %v", err)
+		return nil, fmt.Errorf("failed to read response: %w", apierr.Transient(err))
 	}
 
 	var result map[string]interface{}
 	if err := json.Unmarshal(body, &result); err != nil {
-		return fmt.Errorf("failed to parse response: %v", err)
+		if resp.StatusCode != http.StatusOK {
+			return nil, apierr.FromStatus(resp.StatusCode, fmt.Errorf("dynalist API error (HTTP %d)", resp.StatusCode))
+		}
+		return nil, fmt.Errorf("failed to parse response: %v", err)
 	}
 
 	c.logger.Debug("Dynalist API response: %v", result)
@@ -87,21 +262,27 @@ func (c *APIClient) AddToInbox(content, note string) error {
 
 		switch code {
 		case "TooManyRequests":
-			c.logger.Warn("Dynalist rate limit hit, pausing for 2 seconds")
-			time.Sleep(2 * time.Second)
-			return fmt.Errorf("dynalist rate limit: %s", msg)
+			c.logger.Warn("Dynalist rate limit hit: %s", msg)
+			return nil, fmt.Errorf("dynalist rate limit: %s: %w", msg, &apierr.RateLimitError{
+				Endpoint: "Dynalist API",
+				Reset:    time.Now().Add(rateLimitWindow),
+			})
 		case "InvalidToken":
 			c.logger.Error("Dynalist token is invalid: %s", msg)
-			return fmt.Errorf("dynalist invalid token: %s", msg)
+			return nil, fmt.Errorf("dynalist %w: %s", apierr.ErrInvalidToken, msg)
 		case "Unauthorized":
+			// Dynalist uses this code when the token has no access to the document.
 			c.logger.Error("Dynalist unauthorized: %s", msg)
-			return fmt.Errorf("dynalist unauthorized: %s", msg)
+			return nil, fmt.Errorf("dynalist unauthorized: %s: %w", msg, apierr.ErrForbidden)
+		case "NotFound", "NodeNotFound":
+			return nil, fmt.Errorf("dynalist %w: %s", apierr.ErrNotFound, msg)
+		case "LockFail":
+			return nil, fmt.Errorf("dynalist document is locked: %s: %w", msg, apierr.ErrTransient)
 		default:
 			c.logger.Error("Dynalist API error [%s]: %s", code, msg)
-			return fmt.Errorf("dynalist API error [%s]: %s", code, msg)
+			return nil, fmt.Errorf("dynalist API error [%s]: %s", code, msg)
 		}
 	}
 
-	c.logger.Debug("Successfully added item to Dynalist inbox")
-	return nil
+	return result, nil
 }
@@ -2,32 +2,42 @@ package dynalist
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/korjavin/tw2dynalist/internal/apierr"
 	"github.com/korjavin/tw2dynalist/internal/logger"
 )
 
 func TestAPIClient_AddToInbox_Success(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/inbox/add" {
+			t.Errorf("Expected to request '/inbox/add', got '%s'", r.URL.Path)
+		}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]string{
-			"_code": "Ok",
-			"_msg":  "Item added",
+			"_code":   "Ok",
+			"_msg":    "Item added",
+			"file_id": "file1",
+			"node_id": "node1",
 		})
 	}))
 	defer server.Close()
 
 	log := logger.New("DEBUG")
-	client := NewClient("test_token", log)
+	client := NewClient(server.URL, "test_token", log)
 	client.client = server.Client() // Use the test server's client
-	client.BaseURL = server.URL
 
-	err := client.AddToInbox("test content", "test note")
+	node, err := client.AddToInbox("test content", "test note")
 	if err != nil {
 		t.Fatalf("AddToInbox() returned an error: %v", err)
 	}
+	if node.FileID != "file1" || node.NodeID != "node1" {
+		t.Errorf("Expected node file1/node1, got %s/%s", node.FileID, node.NodeID)
+	}
 }
 
 func TestAPIClient_AddToInbox_RateLimit(t *testing.T) {
@@ -42,16 +52,17 @@ func TestAPIClient_AddToInbox_RateLimit(t *testing.T) {
 	defer server.Close()
 
 	log := logger.New("DEBUG")
-	client := NewClient("test_token", log)
+	client := NewClient(server.URL, "test_token", log)
 	client.client = server.Client()
 	client.BaseURL = server.URL
 
-	err := client.AddToInbox("test content", "test note")
+	_, err := client.AddToInbox("test content", "test note")
 	if err == nil {
 		t.Fatal("AddToInbox() should have returned an error for rate limit")
 	}
-	if err.Error() != "dynalist rate limit: Rate limit exceeded" {
-		t.Errorf("Expected rate limit error, got: %v", err)
+	var rateLimitErr *apierr.RateLimitError
+	if !errors.As(err, &rateLimitErr) || !rateLimitErr.Reset.After(time.Now()) {
+		t.Errorf("Expected a RateLimitError with a reset time, got: %v", err)
 	}
 }
 
@@ -67,15 +78,158 @@ func TestAPIClient_AddToInbox_InvalidToken(t *testing.T) {
 	defer server.Close()
 
 	log := logger.New("DEBUG")
-	client := NewClient("test_token", log)
+	client := NewClient(server.URL, "test_token", log)
 	client.client = server.Client()
 	client.BaseURL = server.URL
 
-	err := client.AddToInbox("test content", "test note")
+	_, err := client.AddToInbox("test content", "test note")
 	if err == nil {
 		t.Fatal("AddToInbox() should have returned an error for invalid token")
 	}
+	if !errors.Is(err, apierr.ErrInvalidToken) {
+		t.Errorf("Expected ErrInvalidToken, got: %v", err)
+	}
 	if err.Error() != "dynalist invalid token: Token is invalid" {
 		t.Errorf("Expected invalid token error, got: %v", err)
 	}
 }
+
+func TestAPIClient_AddToInbox_Unavailable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+	}))
+
+	log := logger.New("DEBUG")
+	client := NewClient(server.URL, "test_token", log)
+	client.client = server.Client()
+
+	_, err := client.AddToInbox("test content", "test note")
+	if !errors.Is(err, apierr.ErrTransient) {
+		t.Errorf("Expected ErrTransient for a 502, got: %v", err)
+	}
+
+	server.Close()
+	_, err = client.AddToInbox("test content", "test note")
+	if !errors.Is(err, apierr.ErrTransient) {
+		t.Errorf("Expected ErrTransient when the server is down, got: %v", err)
+	}
+}
+
+func TestAPIClient_AddChildren(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req DocEditRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+			return
+		}
+		if req.FileID != "file1" {
+			t.Errorf("Expected file ID 'file1', got '%s'", req.FileID)
+		}
+		if len(req.Changes) != 2 {
+			t.Errorf("Expected 2 changes, got %d", len(req.Changes))
+			return
+		}
+		for i, change := range req.Changes {
+			if change.Action != "insert" || change.ParentID != "node1" || change.Index != i {
+				t.Errorf("Unexpected change %d: %+v", i, change)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"_code": "Ok"})
+	}))
+	defer server.Close()
+
+	log := logger.New("DEBUG")
+	client := NewClient(server.URL, "test_token", log)
+	client.client = server.Client()
+	client.DocEditURL = server.URL
+
+	children := []Item{{Content: "second"}, {Content: "third", Note: "note"}}
+	if err := client.AddChildren(&Node{FileID: "file1", NodeID: "node1"}, children); err != nil {
+		t.Fatalf("AddChildren() returned an error: %v", err)
+	}
+}
+
+func TestAPIClient_AddToDocument(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req DocEditRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+			return
+		}
+		if req.FileID != "file1" || len(req.Changes) != 1 || req.Changes[0].ParentID != "root" {
+			t.Errorf("Unexpected request: %+v", req)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"_code":        "Ok",
+			"new_node_ids": []string{"node9"},
+		})
+	}))
+	defer server.Close()
+
+	log := logger.New("DEBUG")
+	client := NewClient(server.URL, "test_token", log)
+	client.client = server.Client()
+	client.DocEditURL = server.URL
+
+	node, err := client.AddToDocument("file1", "root", "test content", "test note")
+	if err != nil {
+		t.Fatalf("AddToDocument() returned an error: %v", err)
+	}
+	if node.FileID != "file1" || node.NodeID != "node9" {
+		t.Errorf("Expected node file1/node9, got %s/%s", node.FileID, node.NodeID)
+	}
+}
+
+func TestAPIClient_Annotate(t *testing.T) {
+	var edits []DocEditChange
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/doc/read":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"_code": "Ok",
+				"nodes": []map[string]string{
+					{"id": "root", "content": ""},
+					{"id": "node1", "content": "Tweet: hello"},
+					{"id": "node2", "content": "Tweet: bye (deleted on X)"},
+				},
+			})
+		case "/doc/edit":
+			var req DocEditRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Errorf("Failed to decode request: %v", err)
+				return
+			}
+			edits = append(edits, req.Changes...)
+			json.NewEncoder(w).Encode(map[string]string{"_code": "Ok"})
+		default:
+			t.Errorf("Unexpected request to '%s'", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	log := logger.New("DEBUG")
+	client := NewClient(server.URL, "test_token", log)
+	client.client = server.Client()
+
+	if err := client.Annotate(&Node{FileID: "file1", NodeID: "node1"}, "(deleted on X)"); err != nil {
+		t.Fatalf("Annotate() returned an error: %v", err)
+	}
+	if len(edits) != 1 || edits[0].Action != "edit" || edits[0].NodeID != "node1" || edits[0].Content != "Tweet: hello (deleted on X)" {
+		t.Fatalf("Expected node1 to be edited with the annotation, got %+v", edits)
+	}
+
+	if err := client.Annotate(&Node{FileID: "file1", NodeID: "node2"}, "(deleted on X)"); err != nil {
+		t.Fatalf("Annotate() returned an error: %v", err)
+	}
+	if len(edits) != 1 {
+		t.Errorf("Expected an annotated node to be left alone, got %+v", edits)
+	}
+
+	err := client.Annotate(&Node{FileID: "file1", NodeID: "missing"}, "(deleted on X)")
+	if !errors.Is(err, apierr.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a missing node, got %v", err)
+	}
+}
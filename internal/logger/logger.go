@@ -7,7 +7,8 @@ import (
 
 // Logger provides different log levels.
 type Logger struct {
-	level string
+	level  string
+	prefix string
 }
 
 // New creates a new logger with the specified level.
@@ -17,33 +18,41 @@ func New(level string) *Logger {
 	}
 }
 
+// WithPrefix returns a logger with the same level that starts every message with prefix.
+func (l *Logger) WithPrefix(prefix string) *Logger {
+	return &Logger{
+		level:  l.level,
+		prefix: l.prefix + prefix,
+	}
+}
+
 // Debug logs debug messages.
 func (l *Logger) Debug(format string, v ...interface{}) {
 	if l.level == "DEBUG" {
-		log.Printf("[DEBUG] "+format, v...)
+		log.Printf("[DEBUG] "+l.prefix+format, v...)
 	}
 }
 
 // Info logs info messages.
 func (l *Logger) Info(format string, v ...interface{}) {
 	if l.level == "DEBUG" || l.level == "INFO" {
-		log.Printf("[INFO] "+format, v...)
+		log.Printf("[INFO] "+l.prefix+format, v...)
 	}
 }
 
 // Warn logs warning messages.
 func (l *Logger) Warn(format string, v ...interface{}) {
 	if l.level == "DEBUG" || l.level == "INFO" || l.level == "WARN" {
-		log.Printf("[WARN] "+format, v...)
+		log.Printf("[WARN] "+l.prefix+format, v...)
 	}
 }
 
 // Error logs error messages.
 func (l *Logger) Error(format string, v ...interface{}) {
-	log.Printf("[ERROR] "+format, v...)
+	log.Printf("[ERROR] "+l.prefix+format, v...)
 }
 
 // Fatal logs fatal messages and exits.
 func (l *Logger) Fatal(format string, v ...interface{}) {
-	log.Fatalf("[FATAL] "+format, v...)
+	log.Fatalf("[FATAL] "+l.prefix+format, v...)
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 
+	"github.com/korjavin/tw2dynalist/internal/apierr"
 	"github.com/korjavin/tw2dynalist/internal/logger"
 )
 
@@ -48,12 +49,12 @@ func (c *client) Send(message string, title string) error {
 	}
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to send ntfy notification: %w", err)
+		return fmt.Errorf("failed to send ntfy notification: %w", apierr.Transient(err))
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to send ntfy notification, status code: %d", resp.StatusCode)
+		return apierr.FromStatus(resp.StatusCode, fmt.Errorf("failed to send ntfy notification, status code: %d", resp.StatusCode))
 	}
 
 	c.logger.Info("Sent ntfy notification to topic %s", c.topic)
@@ -1,6 +1,7 @@
 package scheduler
 
 import (
+	"sync"
 	"time"
 
 	"github.com/korjavin/tw2dynalist/internal/logger"
@@ -10,6 +11,8 @@ import (
 type Scheduler interface {
 	Start()
 	Stop()
+	// DelayUntil postpones the next run to t if it would otherwise happen earlier.
+	DelayUntil(t time.Time)
 }
 
 // SimpleScheduler is a basic scheduler that runs a task at a fixed interval.
@@ -18,6 +21,9 @@ type SimpleScheduler struct {
 	task     func()
 	stop     chan struct{}
 	logger   *logger.Logger
+
+	mu        sync.Mutex
+	notBefore time.Time
 }
 
 // NewSimpleScheduler creates a new SimpleScheduler.
@@ -33,17 +39,17 @@ func NewSimpleScheduler(interval time.Duration, task func(), logger *logger.Logg
 // Start begins the scheduler's ticking.
 func (s *SimpleScheduler) Start() {
 	s.logger.Info("Scheduler started, running task every %v", s.interval)
-	ticker := time.NewTicker(s.interval)
-	defer ticker.Stop()
 
 	// Run the task once immediately
 	s.task()
 
 	for {
+		timer := time.NewTimer(s.nextWait())
 		select {
-		case <-ticker.C:
+		case <-timer.C:
 			s.task()
 		case <-s.stop:
+			timer.Stop()
 			s.logger.Info("Scheduler stopped")
 			return
 		}
@@ -54,3 +60,25 @@ func (s *SimpleScheduler) Start() {
 func (s *SimpleScheduler) Stop() {
 	close(s.stop)
 }
+
+// DelayUntil postpones the next run to t if it would otherwise happen earlier.
+func (s *SimpleScheduler) DelayUntil(t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t.After(s.notBefore) {
+		s.notBefore = t
+	}
+}
+
+// nextWait returns how long to wait before the next run, consuming any pending delay.
+func (s *SimpleScheduler) nextWait() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	wait := s.interval
+	if until := time.Until(s.notBefore); until > wait {
+		s.logger.Info("Next run delayed until %s", s.notBefore.Format(time.RFC3339))
+		wait = until
+	}
+	s.notBefore = time.Time{}
+	return wait
+}
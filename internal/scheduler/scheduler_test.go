@@ -47,3 +47,38 @@ func TestSimpleScheduler(t *testing.T) {
 		// success
 	}
 }
+
+func TestSimpleSchedulerDelayUntil(t *testing.T) {
+	log := logger.New("DEBUG")
+	taskExecuted := make(chan bool, 1)
+
+	var scheduler *SimpleScheduler
+	first := true
+	task := func() {
+		if first {
+			first = false
+			scheduler.DelayUntil(time.Now().Add(300 * time.Millisecond))
+		}
+		taskExecuted <- true
+	}
+
+	scheduler = NewSimpleScheduler(50*time.Millisecond, task, log)
+	go scheduler.Start()
+	defer scheduler.Stop()
+
+	<-taskExecuted
+
+	// The regular interval must not trigger a run before the delay has passed
+	select {
+	case <-taskExecuted:
+		t.Fatal("Task was executed before the delay had passed")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	select {
+	case <-taskExecuted:
+		// success
+	case <-time.After(300 * time.Millisecond):
+		t.Fatal("Task was not executed after the delay")
+	}
+}
@@ -0,0 +1,93 @@
+package source
+
+import (
+	"strings"
+	"time"
+
+	"github.com/korjavin/tw2dynalist/internal/storage"
+)
+
+// Item is a single piece of content to save to Dynalist, independent of where it came from.
+type Item struct {
+	// ID identifies the item within its source.
+	ID string
+	// Source is the name of the source the item came from.
+	Source string
+	// Prefix, if not empty, names the type of content, such as "Tweet", and is put in
+	// front of the text when the item is saved.
+	Prefix    string
+	Text      string
+	URL       string
+	Author    string
+	CreatedAt time.Time
+	// Attachments are photos, videos and other files the item links to.
+	Attachments []Attachment
+	// References are other items this one refers to, such as quoted tweets.
+	References []Reference
+	// Children are follow-up items saved beneath this one, such as the rest of a thread.
+	Children []Item
+	// Folder is the name of the folder the item was sorted into at its source, if any.
+	Folder string
+	// Gone, if not empty, says why the item no longer exists at its source, such as
+	// "deleted on X". Such an item has nothing but its ID and URL.
+	Gone string
+}
+
+// Attachment is a file attached to an item.
+type Attachment struct {
+	// Type describes the file, such as "photo" or "video".
+	Type        string
+	URL         string
+	Description string
+}
+
+// Reference is an item referred to by another item.
+type Reference struct {
+	// Label describes how the item is referred to, such as "Quoted".
+	Label string
+	Item  Item
+}
+
+// Source provides the items to save. Its name namespaces the source's state in storage,
+// so that sources never share processed items or cursors.
+type Source interface {
+	// Name identifies the source, such as "bookmarks".
+	Name() string
+	// Kind describes a single item in notifications, such as "Bookmark".
+	Kind() string
+	// Fetch returns the source's items, newest first. store is the source's namespace of
+	// the cache, which the source may use to stop fetching once it reaches known items.
+	// Along with an error, the items fetched before it occurred may be returned.
+	Fetch(store storage.Storage) ([]Item, error)
+}
+
+// Acknowledger is implemented by sources that act on an item after it was saved, such as
+// removing a bookmark.
+type Acknowledger interface {
+	Acknowledge(item Item) error
+}
+
+// Committer is implemented by sources that keep a cursor. Commit is called once all items
+// of the last Fetch were saved, so that the next Fetch starts after them.
+type Committer interface {
+	Commit(store storage.Storage)
+}
+
+// FilterByKeywords returns the items containing at least one of the keywords, ignoring
+// case. Without keywords all items are returned.
+func FilterByKeywords(items []Item, keywords []string) []Item {
+	if len(keywords) == 0 {
+		return items
+	}
+	var matching []Item
+	for _, item := range items {
+		text := strings.ToLower(item.Text)
+		for _, keyword := range keywords {
+			if strings.Contains(text, strings.ToLower(keyword)) {
+				matching = append(matching, item)
+				break
+			}
+		}
+	}
+	return matching
+}
@@ -0,0 +1,24 @@
+package source
+
+import "testing"
+
+func TestFilterByKeywords(t *testing.T) {
+	items := []Item{
+		{ID: "1", Text: "Go generics explained"},
+		{ID: "2", Text: "Lunch photos"},
+		{ID: "3", Text: "New ITERATORS in Go 1.23"},
+	}
+
+	if got := FilterByKeywords(items, nil); len(got) != 3 {
+		t.Errorf("Expected all items without keywords, got %d", len(got))
+	}
+
+	got := FilterByKeywords(items, []string{"generics", "iterators"})
+	if len(got) != 2 || got[0].ID != "1" || got[1].ID != "3" {
+		t.Errorf("Expected items 1 and 3, got %+v", got)
+	}
+
+	if got := FilterByKeywords(items, []string{"rust"}); len(got) != 0 {
+		t.Errorf("Expected no items, got %+v", got)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 
 	"github.com/korjavin/tw2dynalist/internal/logger"
@@ -14,15 +15,45 @@ import (
 type Storage interface {
 	MarkProcessed(tweetID string)
 	IsProcessed(tweetID string) bool
+	// SetNode records where a processed tweet was saved in Dynalist.
+	SetNode(tweetID string, node Node)
+	GetNode(tweetID string) (Node, bool)
+	// MarkGone records that a processed tweet no longer exists at its source, and why.
+	MarkGone(tweetID, reason string)
+	IsGone(tweetID string) bool
+	GetCursor(key string) string
+	SetCursor(key, value string)
 	Save() error
 }
 
+// Node identifies the Dynalist item a tweet was saved as.
+type Node struct {
+	FileID string `json:"file_id"`
+	NodeID string `json:"node_id"`
+}
+
 // FileStorage implements the Storage interface using a local file.
 type FileStorage struct {
 	filePath        string
 	logger          *logger.Logger
 	processedTweets map[string]bool
-	mu              sync.Mutex
+	nodes           map[string]Node
+	gone            map[string]string
+	cursors         map[string]string
+	// changedCursors are the cursors set since the last Save, which take precedence over
+	// those another process saved in the meantime.
+	changedCursors map[string]bool
+	// adoptedNamespace is the namespace unnamespaced processed tweets are moved into.
+	adoptedNamespace string
+	mu               sync.Mutex
+}
+
+// cacheFile is the on-disk layout of the cache.
+type cacheFile struct {
+	ProcessedTweets map[string]bool   `json:"processed_tweets"`
+	Nodes           map[string]Node   `json:"nodes,omitempty"`
+	Gone            map[string]string `json:"gone,omitempty"`
+	Cursors         map[string]string `json:"cursors,omitempty"`
 }
 
 // NewFileStorage initializes a new file-based storage or loads an existing one.
@@ -31,6 +62,10 @@ func NewFileStorage(filePath string, logger *logger.Logger) (*FileStorage, error
 		filePath:        filePath,
 		logger:          logger,
 		processedTweets: make(map[string]bool),
+		nodes:           make(map[string]Node),
+		gone:            make(map[string]string),
+		cursors:         make(map[string]string),
+		changedCursors:  make(map[string]bool),
 	}
 
 	// Create directory if it doesn't exist.
@@ -44,50 +79,113 @@ func NewFileStorage(filePath string, logger *logger.Logger) (*FileStorage, error
 
 	// Try to load existing cache.
 	logger.Debug("Attempting to load cache from: %s", filePath)
+	cache, err := readCacheFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if cache == nil {
+		// Cache file doesn't exist, return empty cache.
+		logger.Info("Cache file doesn't exist, creating new cache")
+		return storage, nil
+	}
+	storage.merge(cache)
+
+	logger.Info("Cache loaded successfully with %d processed tweets", len(storage.processedTweets))
+	return storage, nil
+}
+
+// readCacheFile reads the cache file at filePath, returning nil if it does not exist.
+func readCacheFile(filePath string) (*cacheFile, error) {
 	data, err := os.ReadFile(filePath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			// Cache file doesn't exist, return empty cache.
-			logger.Info("Cache file doesn't exist, creating new cache")
-			return storage, nil
+			return nil, nil
 		}
 		return nil, fmt.Errorf("failed to read cache file: %v", err)
 	}
 
-	// Parse cache data.
-	logger.Debug("Parsing cache data")
-	if err := json.Unmarshal(data, &storage.processedTweets); err != nil {
-		// For backward compatibility with the old format, try to unmarshal into a struct
-		var oldCache struct {
-			ProcessedTweets map[string]bool `json:"processed_tweets"`
+	var cache cacheFile
+	if err := json.Unmarshal(data, &cache); err == nil && cache.ProcessedTweets != nil {
+		return &cache, nil
+	}
+	// For backward compatibility with the flat format, which held only processed tweets
+	cache = cacheFile{}
+	if err := json.Unmarshal(data, &cache.ProcessedTweets); err != nil {
+		return nil, fmt.Errorf("failed to parse cache file: %v", err)
+	}
+	return &cache, nil
+}
+
+// merge adds the contents of cache that s does not have yet. Cursors are taken from cache
+// unless they were set since the last Save. s.mu must be held, or s not yet shared.
+func (s *FileStorage) merge(cache *cacheFile) {
+	for key, processed := range cache.ProcessedTweets {
+		if s.adoptedNamespace != "" && !strings.Contains(key, ":") {
+			key = s.adoptedNamespace + ":" + key
 		}
-		if err2 := json.Unmarshal(data, &oldCache); err2 == nil {
-			storage.processedTweets = oldCache.ProcessedTweets
-			logger.Info("Successfully converted old cache format")
-		} else {
-			return nil, fmt.Errorf("failed to parse cache file: %v", err)
+		if _, ok := s.processedTweets[key]; !ok {
+			s.processedTweets[key] = processed
+		}
+	}
+	for key, node := range cache.Nodes {
+		if _, ok := s.nodes[key]; !ok {
+			s.nodes[key] = node
+		}
+	}
+	for key, reason := range cache.Gone {
+		if _, ok := s.gone[key]; !ok {
+			s.gone[key] = reason
+		}
+	}
+	for key, value := range cache.Cursors {
+		if !s.changedCursors[key] {
+			s.cursors[key] = value
 		}
 	}
-
-	logger.Info("Cache loaded successfully with %d processed tweets", len(storage.processedTweets))
-	return storage, nil
 }
 
-// Save persists the cache to disk.
+// Save persists the cache to disk. Another process, such as an archive import next to the
+// running service, may have saved the same file since it was loaded, so its contents are
+// merged in first rather than overwritten.
 func (s *FileStorage) Save() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	unlock, err := lockFile(s.filePath + ".lock")
+	if err != nil {
+		return fmt.Errorf("failed to lock cache file: %v", err)
+	}
+	defer unlock()
+
+	cache, err := readCacheFile(s.filePath)
+	if err != nil {
+		return err
+	}
+	if cache != nil {
+		s.merge(cache)
+	}
+
 	s.logger.Debug("Marshaling cache data")
-	data, err := json.MarshalIndent(s.processedTweets, "", "  ")
+	data, err := json.MarshalIndent(cacheFile{
+		ProcessedTweets: s.processedTweets,
+		Nodes:           s.nodes,
+		Gone:            s.gone,
+		Cursors:         s.cursors,
+	}, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal cache: %v", err)
 	}
 
+	// Write a temporary file and rename it, so that a reader never sees a partial file.
 	s.logger.Debug("Writing cache to file: %s", s.filePath)
-	if err := os.WriteFile(s.filePath, data, 0644); err != nil {
+	tmpPath := s.filePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
 		return fmt.Errorf("failed to write cache file: %v", err)
 	}
+	if err := os.Rename(tmpPath, s.filePath); err != nil {
+		return fmt.Errorf("failed to write cache file: %v", err)
+	}
+	s.changedCursors = make(map[string]bool)
 
 	s.logger.Info("Cache saved successfully with %d processed tweets", len(s.processedTweets))
 	return nil
@@ -106,3 +204,121 @@ func (s *FileStorage) IsProcessed(tweetID string) bool {
 	defer s.mu.Unlock()
 	return s.processedTweets[tweetID]
 }
+
+// SetNode records the Dynalist item a tweet was saved as.
+func (s *FileStorage) SetNode(tweetID string, node Node) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nodes[tweetID] = node
+}
+
+// GetNode returns the Dynalist item a tweet was saved as, if it is known. It is not for
+// tweets saved before nodes were recorded.
+func (s *FileStorage) GetNode(tweetID string) (Node, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	node, ok := s.nodes[tweetID]
+	return node, ok
+}
+
+// MarkGone records that a tweet no longer exists at its source, such as a deleted tweet.
+func (s *FileStorage) MarkGone(tweetID, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gone[tweetID] = reason
+}
+
+// IsGone checks if a tweet was marked as gone from its source.
+func (s *FileStorage) IsGone(tweetID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.gone[tweetID]
+	return ok
+}
+
+// GetCursor returns the cursor stored under key, or an empty string if there is none.
+func (s *FileStorage) GetCursor(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cursors[key]
+}
+
+// SetCursor stores a cursor, such as the newest tweet ID a source has seen.
+func (s *FileStorage) SetCursor(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cursors[key] = value
+	s.changedCursors[key] = true
+}
+
+// AdoptUnnamespaced moves the processed tweets recorded without a namespace, as all
+// bookmarks were before sources had their own namespaces, into namespace. Those merged in
+// from the file by a later Save are moved as well. It returns how many were moved.
+func (s *FileStorage) AdoptUnnamespaced(namespace string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.adoptedNamespace = namespace
+	moved := 0
+	for key, processed := range s.processedTweets {
+		if strings.Contains(key, ":") {
+			continue
+		}
+		delete(s.processedTweets, key)
+		s.processedTweets[namespace+":"+key] = processed
+		moved++
+	}
+	return moved
+}
+
+// namespacedStorage prefixes every tweet ID and cursor key with a namespace, so that several
+// sources can share one cache file without sharing their state.
+type namespacedStorage struct {
+	Storage
+	prefix string
+}
+
+// WithNamespace returns a Storage that keeps its processed tweets apart from those of s
+// and of other namespaces. Save persists the underlying storage.
+func WithNamespace(s Storage, namespace string) Storage {
+	return &namespacedStorage{Storage: s, prefix: namespace + ":"}
+}
+
+// MarkProcessed marks a tweet as processed within the namespace.
+func (n *namespacedStorage) MarkProcessed(tweetID string) {
+	n.Storage.MarkProcessed(n.prefix + tweetID)
+}
+
+// IsProcessed checks if a tweet has been processed within the namespace.
+func (n *namespacedStorage) IsProcessed(tweetID string) bool {
+	return n.Storage.IsProcessed(n.prefix + tweetID)
+}
+
+// SetNode records the Dynalist item a tweet was saved as within the namespace.
+func (n *namespacedStorage) SetNode(tweetID string, node Node) {
+	n.Storage.SetNode(n.prefix+tweetID, node)
+}
+
+// GetNode returns the Dynalist item a tweet was saved as within the namespace.
+func (n *namespacedStorage) GetNode(tweetID string) (Node, bool) {
+	return n.Storage.GetNode(n.prefix + tweetID)
+}
+
+// MarkGone records that a tweet is gone from its source within the namespace.
+func (n *namespacedStorage) MarkGone(tweetID, reason string) {
+	n.Storage.MarkGone(n.prefix+tweetID, reason)
+}
+
+// IsGone checks if a tweet was marked as gone within the namespace.
+func (n *namespacedStorage) IsGone(tweetID string) bool {
+	return n.Storage.IsGone(n.prefix + tweetID)
+}
+
+// GetCursor returns the cursor stored under key within the namespace.
+func (n *namespacedStorage) GetCursor(key string) string {
+	return n.Storage.GetCursor(n.prefix + key)
+}
+
+// SetCursor stores a cursor under key within the namespace.
+func (n *namespacedStorage) SetCursor(key, value string) {
+	n.Storage.SetCursor(n.prefix+key, value)
+}
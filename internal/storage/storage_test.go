@@ -71,3 +71,190 @@ func TestFileStorage_OldFormatCompatibility(t *testing.T) {
 		t.Error("IsProcessed() should return true for a tweet loaded from old format cache")
 	}
 }
+
+func TestWithNamespace(t *testing.T) {
+	log := logger.New("DEBUG")
+	cacheFile := filepath.Join(t.TempDir(), "cache.json")
+
+	storage, err := NewFileStorage(cacheFile, log)
+	if err != nil {
+		t.Fatalf("NewFileStorage() returned an error: %v", err)
+	}
+	likes := WithNamespace(storage, "likes")
+
+	likes.MarkProcessed("123")
+	if !likes.IsProcessed("123") {
+		t.Error("IsProcessed() should return true for a tweet processed in the namespace")
+	}
+	if storage.IsProcessed("123") {
+		t.Error("IsProcessed() should return false outside of the namespace")
+	}
+
+	storage.MarkProcessed("456")
+	if likes.IsProcessed("456") {
+		t.Error("IsProcessed() should not see tweets processed outside of the namespace")
+	}
+}
+
+func TestFileStorage_Cursors(t *testing.T) {
+	log := logger.New("DEBUG")
+	cacheFile := filepath.Join(t.TempDir(), "cache.json")
+
+	storage, err := NewFileStorage(cacheFile, log)
+	if err != nil {
+		t.Fatalf("NewFileStorage() returned an error: %v", err)
+	}
+	if storage.GetCursor("list:1") != "" {
+		t.Error("GetCursor() should return an empty string for an unknown key")
+	}
+
+	storage.MarkProcessed("123")
+	storage.SetCursor("list:1", "999")
+	WithNamespace(storage, "search").SetCursor("q", "888")
+	if err := storage.Save(); err != nil {
+		t.Fatalf("Save() returned an error: %v", err)
+	}
+
+	newStorage, err := NewFileStorage(cacheFile, log)
+	if err != nil {
+		t.Fatalf("NewFileStorage() returned an error when loading: %v", err)
+	}
+	if got := newStorage.GetCursor("list:1"); got != "999" {
+		t.Errorf("GetCursor() should return '999' after loading, got '%s'", got)
+	}
+	if got := WithNamespace(newStorage, "search").GetCursor("q"); got != "888" {
+		t.Errorf("GetCursor() should return '888' within the namespace after loading, got '%s'", got)
+	}
+	if !newStorage.IsProcessed("123") {
+		t.Error("IsProcessed() should return true for a tweet loaded from cache")
+	}
+}
+
+func TestFileStorage_NodesAndGone(t *testing.T) {
+	log := logger.New("DEBUG")
+	cacheFile := filepath.Join(t.TempDir(), "cache.json")
+
+	storage, err := NewFileStorage(cacheFile, log)
+	if err != nil {
+		t.Fatalf("NewFileStorage() returned an error: %v", err)
+	}
+	bookmarks := WithNamespace(storage, "bookmarks")
+	bookmarks.MarkProcessed("123")
+	bookmarks.SetNode("123", Node{FileID: "inbox", NodeID: "abc"})
+	bookmarks.MarkGone("123", "deleted on X")
+	if err := storage.Save(); err != nil {
+		t.Fatalf("Save() returned an error: %v", err)
+	}
+
+	newStorage, err := NewFileStorage(cacheFile, log)
+	if err != nil {
+		t.Fatalf("NewFileStorage() returned an error when loading: %v", err)
+	}
+	bookmarks = WithNamespace(newStorage, "bookmarks")
+	if node, ok := bookmarks.GetNode("123"); !ok || node != (Node{FileID: "inbox", NodeID: "abc"}) {
+		t.Errorf("GetNode() should return the node after loading, got %+v, %v", node, ok)
+	}
+	if !bookmarks.IsGone("123") {
+		t.Error("IsGone() should return true for a tweet marked gone")
+	}
+	if _, ok := WithNamespace(newStorage, "likes").GetNode("123"); ok {
+		t.Error("GetNode() should not return the node of another namespace")
+	}
+	if WithNamespace(newStorage, "likes").IsGone("123") {
+		t.Error("IsGone() should return false in another namespace")
+	}
+}
+
+func TestFileStorage_SaveMergesOtherProcesses(t *testing.T) {
+	log := logger.New("DEBUG")
+	cacheFile := filepath.Join(t.TempDir(), "cache.json")
+
+	daemon, err := NewFileStorage(cacheFile, log)
+	if err != nil {
+		t.Fatalf("NewFileStorage() returned an error: %v", err)
+	}
+	daemon.SetCursor("bookmarks:newest_id", "100")
+	if err := daemon.Save(); err != nil {
+		t.Fatalf("Save() returned an error: %v", err)
+	}
+
+	// An import opens the same file and saves while the daemon keeps running.
+	importer, err := NewFileStorage(cacheFile, log)
+	if err != nil {
+		t.Fatalf("NewFileStorage() returned an error: %v", err)
+	}
+	importer.MarkProcessed("likes:1")
+	importer.SetCursor("likes:archive_import", "1")
+	if err := importer.Save(); err != nil {
+		t.Fatalf("Save() returned an error: %v", err)
+	}
+
+	daemon.MarkProcessed("bookmarks:2")
+	daemon.SetCursor("bookmarks:newest_id", "200")
+	if err := daemon.Save(); err != nil {
+		t.Fatalf("Save() returned an error: %v", err)
+	}
+	if !daemon.IsProcessed("likes:1") {
+		t.Error("Expected the daemon to see the tweet saved by the import")
+	}
+
+	if err := importer.Save(); err != nil {
+		t.Fatalf("Save() returned an error: %v", err)
+	}
+	loaded, err := NewFileStorage(cacheFile, log)
+	if err != nil {
+		t.Fatalf("NewFileStorage() returned an error when loading: %v", err)
+	}
+	if !loaded.IsProcessed("likes:1") || !loaded.IsProcessed("bookmarks:2") {
+		t.Error("Expected the processed tweets of both processes to be kept")
+	}
+	if got := loaded.GetCursor("bookmarks:newest_id"); got != "200" {
+		t.Errorf("Expected the daemon's cursor '200' to be kept, got '%s'", got)
+	}
+	if got := loaded.GetCursor("likes:archive_import"); got != "1" {
+		t.Errorf("Expected the import's cursor '1' to be kept, got '%s'", got)
+	}
+}
+
+func TestFileStorage_FlatFormatCompatibility(t *testing.T) {
+	log := logger.New("DEBUG")
+	cacheFile := filepath.Join(t.TempDir(), "cache.json")
+
+	// Create a cache file in the flat format written by earlier versions
+	if err := os.WriteFile(cacheFile, []byte(`{"123": true}`), 0644); err != nil {
+		t.Fatalf("Failed to write flat format cache file: %v", err)
+	}
+
+	storage, err := NewFileStorage(cacheFile, log)
+	if err != nil {
+		t.Fatalf("NewFileStorage() returned an error when loading flat format: %v", err)
+	}
+	if !storage.IsProcessed("123") {
+		t.Error("IsProcessed() should return true for a tweet loaded from flat format cache")
+	}
+}
+
+func TestFileStorage_AdoptUnnamespaced(t *testing.T) {
+	log := logger.New("DEBUG")
+	cacheFile := filepath.Join(t.TempDir(), "cache.json")
+
+	storage, err := NewFileStorage(cacheFile, log)
+	if err != nil {
+		t.Fatalf("NewFileStorage() returned an error: %v", err)
+	}
+	storage.MarkProcessed("123")
+	WithNamespace(storage, "likes").MarkProcessed("456")
+
+	if moved := storage.AdoptUnnamespaced("bookmarks"); moved != 1 {
+		t.Errorf("AdoptUnnamespaced() should move 1 tweet, moved %d", moved)
+	}
+	if storage.IsProcessed("123") || !WithNamespace(storage, "bookmarks").IsProcessed("123") {
+		t.Error("IsProcessed() should only see the moved tweet within the namespace")
+	}
+	if !WithNamespace(storage, "likes").IsProcessed("456") || WithNamespace(storage, "bookmarks").IsProcessed("456") {
+		t.Error("AdoptUnnamespaced() should leave tweets in other namespaces alone")
+	}
+	if moved := storage.AdoptUnnamespaced("bookmarks"); moved != 0 {
+		t.Errorf("AdoptUnnamespaced() should move nothing the second time, moved %d", moved)
+	}
+}
@@ -0,0 +1,196 @@
+package twitter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	twitterv2 "github.com/g8rswimmer/go-twitter/v2"
+	"github.com/korjavin/tw2dynalist/internal/apierr"
+)
+
+// tweetFields are the tweet fields requested for every tweet lookup.
+var tweetFields = []twitterv2.TweetField{
+	twitterv2.TweetFieldID,
+	twitterv2.TweetFieldText,
+	twitterv2.TweetFieldAuthorID,
+	twitterv2.TweetFieldCreatedAt,
+	twitterv2.TweetFieldConversationID,
+	twitterv2.TweetFieldInReplyToUserID,
+	twitterv2.TweetFieldReferencedTweets,
+	twitterv2.TweetFieldAttachments,
+	twitterv2.TweetFieldEntities,
+	twitterv2.TweetFieldPublicMetrics,
+	tweetFieldNoteTweet,
+}
+
+// tweetFieldNoteTweet requests the full body of tweets longer than 280 characters.
+// It is not known to the go-twitter library, which is why tweets are decoded here.
+const tweetFieldNoteTweet twitterv2.TweetField = "note_tweet"
+
+// tweetExpansions are the expansions requested for every tweet lookup.
+var tweetExpansions = []twitterv2.Expansion{
+	twitterv2.ExpansionAuthorID,
+	twitterv2.ExpansionReferencedTweetsID,
+	twitterv2.ExpansionReferencedTweetsIDAuthorID,
+	twitterv2.ExpansionAttachmentsMediaKeys,
+}
+
+// mediaFields are the media fields requested for every tweet lookup.
+var mediaFields = []twitterv2.MediaField{
+	twitterv2.MediaFieldMediaKey,
+	twitterv2.MediaFieldType,
+	twitterv2.MediaFieldURL,
+	twitterv2.MediaFieldPreviewImageURL,
+	twitterv2.MediaFieldAltText,
+}
+
+// userFields are the user fields requested for every tweet lookup.
+var userFields = []twitterv2.UserField{
+	twitterv2.UserFieldID,
+	twitterv2.UserFieldName,
+	twitterv2.UserFieldUserName,
+}
+
+// tweetObj is a tweet as returned by the API, including the fields the library does not decode.
+type tweetObj struct {
+	twitterv2.TweetObj
+	NoteTweet *noteTweetObj `json:"note_tweet,omitempty"`
+}
+
+// noteTweetObj holds the full text and entities of a long-form tweet.
+type noteTweetObj struct {
+	Text     string                 `json:"text"`
+	Entities *twitterv2.EntitiesObj `json:"entities,omitempty"`
+}
+
+// tweetsIncludes holds the objects expanded by tweetExpansions.
+type tweetsIncludes struct {
+	Users  []*twitterv2.UserObj  `json:"users,omitempty"`
+	Tweets []*tweetObj           `json:"tweets,omitempty"`
+	Media  []*twitterv2.MediaObj `json:"media,omitempty"`
+}
+
+// tweetsMeta is the pagination metadata shared by the tweet-returning endpoints.
+type tweetsMeta struct {
+	ResultCount int    `json:"result_count"`
+	NextToken   string `json:"next_token"`
+	NewestID    string `json:"newest_id"`
+	OldestID    string `json:"oldest_id"`
+}
+
+// tweetsResponse is a page of tweets from any endpoint that returns a list of tweets.
+type tweetsResponse struct {
+	Tweets   []*tweetObj     `json:"data"`
+	Includes *tweetsIncludes `json:"includes,omitempty"`
+	Errors   []*partialError `json:"errors,omitempty"`
+	Meta     *tweetsMeta     `json:"meta,omitempty"`
+}
+
+// partialError reports an object X could not return, such as a deleted tweet, without
+// failing the whole request.
+type partialError struct {
+	twitterv2.ErrorObj
+	ResourceID string `json:"resource_id"`
+}
+
+// tweetQuery returns the query parameters that request all tweet fields and expansions,
+// merged with the endpoint specific params.
+func tweetQuery(params url.Values) url.Values {
+	q := url.Values{}
+	for key, values := range params {
+		q[key] = values
+	}
+	q.Set("expansions", joinFields(tweetExpansions))
+	q.Set("tweet.fields", joinFields(tweetFields))
+	q.Set("user.fields", joinFields(userFields))
+	q.Set("media.fields", joinFields(mediaFields))
+	return q
+}
+
+func joinFields[T ~string](fields []T) string {
+	strs := make([]string, len(fields))
+	for i, field := range fields {
+		strs[i] = string(field)
+	}
+	return strings.Join(strs, ",")
+}
+
+// getTweets performs a GET against a tweet-returning endpoint, requesting all tweet fields
+// and expansions in addition to params.
+func (c *APIClient) getTweets(path string, params url.Values) (*tweetsResponse, error) {
+	result := &tweetsResponse{}
+	if err := c.getJSON(path, tweetQuery(params), result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// getJSON performs an authorized GET and decodes the JSON response into out. When the token
+// is rejected it is refreshed and the request retried once.
+func (c *APIClient) getJSON(path string, params url.Values, out interface{}) error {
+	if !c.Authorized() {
+		return ErrNotAuthorized
+	}
+	rejected := c.tokens.Token().AccessToken
+	err := c.doGet(path, params, out)
+	if errors.Is(err, apierr.ErrUnauthorized) {
+		c.logger.Warn("Received 401 Unauthorized, attempting to refresh token")
+		if err := c.refresh(rejected); err != nil {
+			return fmt.Errorf("failed to refresh token: %w", err)
+		}
+		c.logger.Info("Retrying %s after token refresh", path)
+		err = c.doGet(path, params, out)
+	}
+	return err
+}
+
+// doGet performs a single GET request. A 429 is returned as *RateLimitError, other non-200
+// responses as *twitterv2.ErrorResponse, or *twitterv2.HTTPError when the body is not JSON,
+// wrapped in the apierr error matching the status.
+func (c *APIClient) doGet(path string, params url.Values, out interface{}) error {
+	endpoint := c.client.Host + path
+	if len(params) > 0 {
+		endpoint += "?" + params.Encode()
+	}
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	c.client.Authorizer.Add(req)
+
+	c.recorder().RecordAPICall(endpointName(path))
+	resp, err := c.client.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", apierr.Transient(err))
+	}
+	defer resp.Body.Close()
+
+	if err := c.checkRateLimit(path, resp); err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		e := &twitterv2.ErrorResponse{}
+		if err := decoder.Decode(e); err != nil {
+			return apierr.FromStatus(resp.StatusCode, &twitterv2.HTTPError{
+				Status:     resp.Status,
+				StatusCode: resp.StatusCode,
+				URL:        resp.Request.URL.String(),
+			})
+		}
+		e.StatusCode = resp.StatusCode
+		return apierr.FromStatus(resp.StatusCode, e)
+	}
+
+	if err := decoder.Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
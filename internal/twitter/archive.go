@@ -0,0 +1,117 @@
+package twitter
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// archiveLikeFiles matches the files holding liked tweets in an X data archive. Large
+// archives split them into like.js, like-part1.js, like-part2.js and so on.
+const archiveLikeFiles = "data/like*.js"
+
+// archiveLike is an entry of the like.js file of an X data archive.
+type archiveLike struct {
+	Like struct {
+		TweetID     string `json:"tweetId"`
+		FullText    string `json:"fullText"`
+		ExpandedURL string `json:"expandedUrl"`
+	} `json:"like"`
+}
+
+// OpenArchive opens an X data archive, either the downloaded zip file or the directory it
+// was extracted to. The returned function closes the archive.
+func OpenArchive(archivePath string) (fs.FS, func() error, error) {
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open archive: %v", err)
+	}
+	if info.IsDir() {
+		return os.DirFS(archivePath), func() error { return nil }, nil
+	}
+	r, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open archive: %v", err)
+	}
+	return r, r.Close, nil
+}
+
+// ReadArchiveLikes returns the liked tweets in an X data archive, most recently liked
+// first. The archive only has the text and URL of each tweet.
+func ReadArchiveLikes(archive fs.FS) ([]Tweet, error) {
+	files, err := fs.Glob(archive, archiveLikeFiles)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no %s files found, is this an X data archive?", archiveLikeFiles)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return archivePart(files[i]) < archivePart(files[j])
+	})
+
+	var tweets []Tweet
+	for _, file := range files {
+		data, err := fs.ReadFile(archive, file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", file, err)
+		}
+		var likes []archiveLike
+		if err := decodeArchiveJS(data, &likes); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", file, err)
+		}
+		for _, like := range likes {
+			if like.Like.TweetID == "" {
+				continue
+			}
+			tweetURL := like.Like.ExpandedURL
+			if tweetURL == "" {
+				tweetURL = "https://twitter.com/i/web/status/" + like.Like.TweetID
+			}
+			tweets = append(tweets, Tweet{
+				ID:   like.Like.TweetID,
+				Text: like.Like.FullText,
+				URL:  tweetURL,
+			})
+		}
+	}
+	return tweets, nil
+}
+
+// decodeArchiveJS decodes an archive file, which is JSON assigned to a JavaScript
+// variable, such as "window.YTD.like.part0 = [...]".
+func decodeArchiveJS(data []byte, out interface{}) error {
+	i := bytes.IndexByte(data, '=')
+	if i < 0 {
+		return fmt.Errorf("no assignment found")
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data[i+1:]))
+	err := decoder.Decode(out)
+	if err == io.EOF {
+		return fmt.Errorf("no data after the assignment")
+	}
+	return err
+}
+
+// archivePart returns the part number of an archive file: 0 for like.js, and n for
+// like-partn.js.
+func archivePart(file string) int {
+	name := strings.TrimSuffix(path.Base(file), ".js")
+	i := strings.LastIndex(name, "-part")
+	if i < 0 {
+		return 0
+	}
+	n, err := strconv.Atoi(name[i+len("-part"):])
+	if err != nil {
+		return 0
+	}
+	return n
+}
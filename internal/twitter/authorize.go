@@ -0,0 +1,534 @@
+package twitter
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/korjavin/tw2dynalist/internal/apierr"
+	"github.com/korjavin/tw2dynalist/internal/auth"
+	"github.com/korjavin/tw2dynalist/internal/config"
+	"github.com/korjavin/tw2dynalist/internal/logger"
+
+	twitterv2 "github.com/g8rswimmer/go-twitter/v2"
+	"golang.org/x/oauth2"
+)
+
+// AuthorizePath is where the dashboard links to start the OAuth flow.
+const AuthorizePath = "/authorize"
+
+// callbackPath is where X redirects to after the user authorized the app.
+const callbackPath = "/callback"
+
+// authFlowTimeout is how long an authorization attempt waits for its callback.
+const authFlowTimeout = 30 * time.Minute
+
+// maxAuthFlows is how many authorization attempts may wait for their callback at once;
+// starting another one drops the oldest.
+const maxAuthFlows = 10
+
+// stateCookie is the cookie binding an authorization attempt to the browser that started
+// it, so that a callback carrying a state from another browser is rejected.
+const stateCookie = "tw2dynalist_oauth_state"
+
+// authFlow is an authorization attempt waiting for its callback, keyed by its state.
+type authFlow struct {
+	codeVerifier string
+	started      time.Time
+}
+
+// OAuth scopes the app requests. Scopes other than these are not used by any feature.
+const (
+	ScopeTweetRead     = "tweet.read"
+	ScopeUsersRead     = "users.read"
+	ScopeBookmarkRead  = "bookmark.read"
+	ScopeBookmarkWrite = "bookmark.write"
+	ScopeLikeRead      = "like.read"
+	ScopeListRead      = "list.read"
+	ScopeOfflineAccess = "offline.access"
+)
+
+// MissingScopeError is returned when X refuses a request because the authorization lacks
+// the scope it needs.
+type MissingScopeError struct {
+	Scope string
+}
+
+func (e *MissingScopeError) Error() string {
+	return fmt.Sprintf("authorization lacks the %s scope, re-authorize to grant it", e.Scope)
+}
+
+// Unwrap returns apierr.ErrForbidden, as X answers requests lacking a scope with a 403.
+func (e *MissingScopeError) Unwrap() error {
+	return apierr.ErrForbidden
+}
+
+// scopeError turns err, the failure of a request needing scope, into a MissingScopeError
+// if X refused the request with a 403. Other errors are returned as is.
+func scopeError(err error, scope string) error {
+	if errors.Is(err, apierr.ErrForbidden) {
+		return fmt.Errorf("%w: %w", &MissingScopeError{Scope: scope}, err)
+	}
+	return err
+}
+
+// ErrNotAuthorized is returned while the user has not (re-)authorized the app with X.
+var ErrNotAuthorized = errors.New("twitter account not authorized, use the Authorize link on the dashboard")
+
+// AuthorizeLink returns the dashboard path that starts the OAuth flow for account, which
+// is empty in single-account mode.
+func AuthorizeLink(account string) string {
+	if account == "" {
+		return AuthorizePath
+	}
+	return AuthorizePath + "?account=" + url.QueryEscape(account)
+}
+
+// Router serves the OAuth endpoints of the clients of all accounts on one mux. All
+// accounts share the callback URL registered with X, so callbacks are routed to the
+// client that started the authorization by their state.
+type Router struct {
+	mu      sync.Mutex
+	clients map[string]*APIClient
+}
+
+// NewRouter registers the OAuth endpoints on mux.
+func NewRouter(mux *http.ServeMux) *Router {
+	r := &Router{clients: make(map[string]*APIClient)}
+	mux.HandleFunc(AuthorizePath, r.handleAuthorize)
+	mux.HandleFunc(callbackPath, r.handleCallback)
+	return r
+}
+
+// add routes the OAuth flows of the account c belongs to to c.
+func (r *Router) add(c *APIClient) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clients[c.config.AccountName] = c
+}
+
+func (r *Router) handleAuthorize(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	client, ok := r.clients[req.URL.Query().Get("account")]
+	r.mu.Unlock()
+	if !ok {
+		http.Error(w, "Unknown account", http.StatusNotFound)
+		return
+	}
+	client.handleAuthorize(w, req)
+}
+
+func (r *Router) handleCallback(w http.ResponseWriter, req *http.Request) {
+	state := req.URL.Query().Get("state")
+	r.mu.Lock()
+	// Without a matching flow, whichever client is picked rejects the callback.
+	var client *APIClient
+	for _, c := range r.clients {
+		client = c
+		if c.hasAuthFlow(state) {
+			break
+		}
+	}
+	r.mu.Unlock()
+	if client == nil {
+		http.NotFound(w, req)
+		return
+	}
+	client.handleCallback(w, req)
+}
+
+// Authorized reports whether the client holds a token to make requests with.
+func (c *APIClient) Authorized() bool {
+	return c.tokens.HasToken()
+}
+
+// GrantedScopes returns the scopes the user granted, or nil if they are not known.
+func (c *APIClient) GrantedScopes() []string {
+	return auth.GrantedScopes(c.tokens.Token())
+}
+
+// OnAuthorized registers fn to be called after the user completes the OAuth flow.
+func (c *APIClient) OnAuthorized(fn func()) {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+	c.onAuthorized = fn
+}
+
+// user returns the ID of the authorized user. If it is not known, because looking it up
+// failed at startup, it is looked up again.
+func (c *APIClient) user() (string, error) {
+	c.authMu.Lock()
+	userID := c.userID
+	c.authMu.Unlock()
+	if userID != "" && userID != "me" {
+		return userID, nil
+	}
+
+	userID, err := c.lookupUserID()
+	if err != nil {
+		return "", err
+	}
+	c.setUser(userID)
+	return userID, nil
+}
+
+// refresh refreshes the token after the API rejected it. If X refuses the refresh, the
+// token is dropped and ErrNotAuthorized returned, so the user can authorize again from the
+// dashboard. The token file is kept, as it is overwritten once that happens. Any other
+// failure, such as an outage of the token endpoint, keeps the token to retry later.
+func (c *APIClient) refresh(rejected string) error {
+	err := c.tokens.Refresh(rejected)
+	if err == nil {
+		return nil
+	}
+	if refreshRefused(err) {
+		c.logger.Error("Token refresh was refused, re-authorization required: %v", err)
+		c.tokens.SetToken(nil)
+		return fmt.Errorf("%w: %w: %v", ErrNotAuthorized, apierr.ErrInvalidToken, err)
+	}
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		return fmt.Errorf("failed to refresh token: %w", apierr.Transient(err))
+	}
+	return err
+}
+
+// refreshRefused reports whether err, the failure of a token refresh, means that the
+// refresh token or the client was rejected, as opposed to the token endpoint failing.
+func refreshRefused(err error) bool {
+	if errors.Is(err, auth.ErrNoRefreshToken) {
+		return true
+	}
+	var retrieveErr *oauth2.RetrieveError
+	if !errors.As(err, &retrieveErr) {
+		return false
+	}
+	if retrieveErr.Response != nil {
+		return retrieveErr.Response.StatusCode == http.StatusBadRequest || retrieveErr.Response.StatusCode == http.StatusUnauthorized
+	}
+	return retrieveErr.ErrorCode == "invalid_grant" || retrieveErr.ErrorCode == "invalid_client"
+}
+
+// handleAuthorize starts a new PKCE flow with a random state and redirects to X's
+// authorization page.
+func (c *APIClient) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	codeVerifier, err := auth.GenerateCodeVerifier()
+	if err != nil {
+		c.logger.Error("Failed to generate code verifier: %v", err)
+		http.Error(w, "Failed to start authorization", http.StatusInternalServerError)
+		return
+	}
+	state, err := auth.GenerateState()
+	if err != nil {
+		c.logger.Error("Failed to generate state: %v", err)
+		http.Error(w, "Failed to start authorization", http.StatusInternalServerError)
+		return
+	}
+
+	c.authMu.Lock()
+	c.pruneAuthFlows()
+	if c.authFlows == nil {
+		c.authFlows = make(map[string]authFlow)
+	}
+	for len(c.authFlows) >= maxAuthFlows {
+		c.dropOldestAuthFlow()
+	}
+	c.authFlows[state] = authFlow{codeVerifier: codeVerifier, started: time.Now()}
+	c.authMu.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     c.stateCookieName(),
+		Value:    state,
+		Path:     "/",
+		MaxAge:   int(authFlowTimeout.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(c.oauth2Config.RedirectURL, "https:"),
+		// Lax, as the callback is a top-level navigation coming from X.
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	authURL := auth.GetAuthURL(c.oauth2Config, state, auth.GenerateCodeChallenge(codeVerifier))
+	c.logger.Debug("Redirecting to authorization URL: %s", authURL)
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// handleCallback completes the PKCE flow started by handleAuthorize. Callbacks with an
+// unknown or expired state are rejected, which also covers repeated callbacks, as a flow
+// is removed once its callback has been received. So are callbacks whose state does not
+// match the state cookie, as they come from a browser that did not start the flow.
+func (c *APIClient) handleCallback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if errorParam := query.Get("error"); errorParam != "" {
+		c.logger.Error("OAuth callback error: %s", errorParam)
+		http.Error(w, fmt.Sprintf("OAuth Error: %s", errorParam), http.StatusBadRequest)
+		return
+	}
+	code := query.Get("code")
+	if code == "" {
+		http.Error(w, "Authorization code not found", http.StatusBadRequest)
+		return
+	}
+
+	state := query.Get("state")
+	if cookie, err := r.Cookie(c.stateCookieName()); err != nil || cookie.Value != state {
+		c.logger.Warn("Rejected OAuth callback whose state does not match the browser's state cookie")
+		http.Error(w, "Authorization was not started from this browser, start again from the dashboard", http.StatusBadRequest)
+		return
+	}
+
+	c.authMu.Lock()
+	c.pruneAuthFlows()
+	flow, ok := c.authFlows[state]
+	delete(c.authFlows, state)
+	c.authMu.Unlock()
+	if !ok {
+		c.logger.Warn("Rejected OAuth callback with unknown or expired state")
+		http.Error(w, "Unknown or expired authorization attempt, start again from the dashboard", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: c.stateCookieName(), Path: "/", MaxAge: -1})
+
+	token, err := auth.ExchangeToken(c.oauth2Config, code, flow.codeVerifier)
+	if err != nil {
+		c.logger.Error("Failed to exchange token: %v", err)
+		http.Error(w, "Failed to exchange authorization code", http.StatusBadGateway)
+		return
+	}
+	c.tokens.SetToken(token)
+
+	userID, err := c.lookupUserID()
+	if err != nil {
+		c.logger.Error("%v", err)
+		c.tokens.SetToken(nil)
+		http.Error(w, "Failed to look up the authorized user", http.StatusBadGateway)
+		return
+	}
+	c.setUser(userID)
+	c.recorder().RecordTokenExpiry(token.Expiry)
+	c.logger.Info("Authorization completed for user ID: %s", userID)
+
+	w.Header().Set("Content-Type", "text/html")
+	w.Write([]byte(`Authorization Successful! You can close this window or go back to the <a href="/">dashboard</a>.`))
+
+	c.authMu.Lock()
+	onAuthorized := c.onAuthorized
+	c.authMu.Unlock()
+	if onAuthorized != nil {
+		go onAuthorized()
+	}
+}
+
+// AuthorizeHeadless runs the OAuth flow without the callback server, for hosts whose
+// redirect URL cannot be reached from the browser. It prints the authorization URL to out
+// and reads the redirect URL, or just its code parameter, from in. The token is saved to
+// the token file, from where the daemon picks it up on its next start.
+func AuthorizeHeadless(cfg *config.Config, logger *logger.Logger, in io.Reader, out io.Writer) error {
+	store := auth.NewTokenStore(cfg.TokenFilePath, cfg.TokenEncryptionKey, logger)
+	return newAPIClient(cfg, logger, store, nil, "").authorizeHeadless(in, out)
+}
+
+func (c *APIClient) authorizeHeadless(in io.Reader, out io.Writer) error {
+	codeVerifier, err := auth.GenerateCodeVerifier()
+	if err != nil {
+		return fmt.Errorf("failed to generate code verifier: %v", err)
+	}
+	state, err := auth.GenerateState()
+	if err != nil {
+		return fmt.Errorf("failed to generate state: %v", err)
+	}
+
+	authURL := auth.GetAuthURL(c.oauth2Config, state, auth.GenerateCodeChallenge(codeVerifier))
+	fmt.Fprintf(out, "Open the following URL in a browser and authorize the application:\n\n%s\n\n", authURL)
+	fmt.Fprintf(out, "You will be redirected to %s, which may fail to load.\n", c.oauth2Config.RedirectURL)
+	fmt.Fprintln(out, "Paste the full URL from the browser's address bar, or just its code parameter:")
+
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && (!errors.Is(err, io.EOF) || line == "") {
+		return fmt.Errorf("failed to read authorization response: %v", err)
+	}
+	code, err := parseAuthorizationResponse(strings.TrimSpace(line), state)
+	if err != nil {
+		return err
+	}
+
+	token, err := auth.ExchangeToken(c.oauth2Config, code, codeVerifier)
+	if err != nil {
+		return fmt.Errorf("failed to exchange token: %v", err)
+	}
+	c.tokens.SetToken(token)
+
+	userID, err := c.lookupUserID()
+	if err != nil {
+		return err
+	}
+	c.authMu.Lock()
+	c.userID = userID
+	c.authMu.Unlock()
+	c.tokens.SetUserID(userID)
+	if err := c.tokens.Save(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Authorized user ID %s, token saved to %s\n", userID, c.config.TokenFilePath)
+	return nil
+}
+
+// parseAuthorizationResponse extracts the authorization code from a pasted redirect URL,
+// verifying its state, or returns input unchanged if it is a bare code.
+func parseAuthorizationResponse(input, state string) (string, error) {
+	if input == "" {
+		return "", fmt.Errorf("no authorization response given")
+	}
+	if !strings.Contains(input, "=") {
+		return input, nil
+	}
+
+	rawQuery := input
+	if i := strings.Index(input, "?"); i >= 0 {
+		rawQuery = input[i+1:]
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse redirect URL: %v", err)
+	}
+	if errorParam := query.Get("error"); errorParam != "" {
+		return "", fmt.Errorf("OAuth Error: %s", errorParam)
+	}
+	if query.Get("state") != state {
+		return "", fmt.Errorf("state in redirect URL does not match, paste the URL from this authorization attempt")
+	}
+	code := query.Get("code")
+	if code == "" {
+		return "", fmt.Errorf("authorization code not found in redirect URL")
+	}
+	return code, nil
+}
+
+// Logout revokes the refresh and access tokens with X, deletes the token file and drops
+// the token, so the app has to be authorized again. The token is removed locally even if
+// revoking fails, in which case an error is returned.
+func (c *APIClient) Logout() error {
+	token := c.tokens.Token()
+	var revokeErrs []error
+	if token.RefreshToken != "" {
+		if err := auth.RevokeToken(c.oauth2Config, c.revokeURL, token.RefreshToken, "refresh_token"); err != nil {
+			revokeErrs = append(revokeErrs, err)
+		}
+	}
+	if token.AccessToken != "" {
+		if err := auth.RevokeToken(c.oauth2Config, c.revokeURL, token.AccessToken, "access_token"); err != nil {
+			revokeErrs = append(revokeErrs, err)
+		}
+	}
+
+	if err := c.tokens.Forget(); err != nil {
+		return err
+	}
+	c.authMu.Lock()
+	c.userID = ""
+	c.authMu.Unlock()
+	c.recorder().RecordTokenExpiry(time.Time{})
+	c.logger.Info("Logged out, removed token file %s", c.config.TokenFilePath)
+
+	if err := errors.Join(revokeErrs...); err != nil {
+		return fmt.Errorf("token removed locally, but revoking it with X failed, revoke the app's access in the X settings instead: %w", err)
+	}
+	return nil
+}
+
+// Logout revokes the token in the token file and deletes the file, for logging out from
+// the command line.
+func Logout(cfg *config.Config, logger *logger.Logger) error {
+	if _, err := os.Stat(cfg.TokenFilePath); os.IsNotExist(err) {
+		logger.Info("No token file found at %s, nothing to log out", cfg.TokenFilePath)
+		return nil
+	}
+
+	store := auth.NewTokenStore(cfg.TokenFilePath, cfg.TokenEncryptionKey, logger)
+	token, userID, err := store.LoadToken()
+	if err != nil {
+		return fmt.Errorf("failed to load token: %v", err)
+	}
+	return newAPIClient(cfg, logger, store, token, userID).Logout()
+}
+
+// hasAuthFlow reports whether c started the authorization attempt with state.
+func (c *APIClient) hasAuthFlow(state string) bool {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+	_, ok := c.authFlows[state]
+	return ok
+}
+
+// pruneAuthFlows drops authorization attempts that have timed out. authMu must be held.
+func (c *APIClient) pruneAuthFlows() {
+	for state, flow := range c.authFlows {
+		if time.Since(flow.started) > authFlowTimeout {
+			delete(c.authFlows, state)
+		}
+	}
+}
+
+// dropOldestAuthFlow drops the authorization attempt started first. authMu must be held.
+func (c *APIClient) dropOldestAuthFlow() {
+	var oldest string
+	for state, flow := range c.authFlows {
+		if oldest == "" || flow.started.Before(c.authFlows[oldest].started) {
+			oldest = state
+		}
+	}
+	delete(c.authFlows, oldest)
+}
+
+// stateCookieName returns the name of the state cookie of c's account, so that accounts
+// can be authorized at the same time from one browser.
+func (c *APIClient) stateCookieName() string {
+	if c.config.AccountName == "" {
+		return stateCookie
+	}
+	return stateCookie + "_" + c.config.AccountName
+}
+
+// setUser stores the authorized user's ID and saves it with the token.
+func (c *APIClient) setUser(userID string) {
+	c.authMu.Lock()
+	c.userID = userID
+	c.authMu.Unlock()
+
+	c.tokens.SetUserID(userID)
+	if err := c.tokens.Save(); err != nil {
+		c.logger.Warn("Failed to save token with user info: %v", err)
+	}
+}
+
+// meResponse is the response of the /2/users/me endpoint.
+type meResponse struct {
+	Data *twitterv2.UserObj `json:"data"`
+}
+
+// lookupUserID resolves the authorized user through the /2/users/me endpoint. TW_USER,
+// if configured, is only compared against the authorized account's username.
+func (c *APIClient) lookupUserID() (string, error) {
+	c.logger.Debug("Looking up the authorized user")
+	var me meResponse
+	if err := c.getJSON("/2/users/me", nil, &me); err != nil {
+		return "", fmt.Errorf("cannot get the authorized user: %w", err)
+	}
+	if me.Data == nil || me.Data.ID == "" {
+		return "", fmt.Errorf("failed to get information about the authorized user")
+	}
+	c.logger.Info("Authorized as @%s (user ID %s)", me.Data.UserName, me.Data.ID)
+
+	expected := strings.TrimPrefix(c.config.TwitterUsername, "@")
+	if expected != "" && !strings.EqualFold(expected, me.Data.UserName) {
+		c.logger.Warn("TW_USER is @%s, but the authorized account is @%s, using the authorized account", expected, me.Data.UserName)
+	}
+	return me.Data.ID, nil
+}
@@ -0,0 +1,61 @@
+package twitter
+
+import (
+	"net/url"
+	"strings"
+
+	twitterv2 "github.com/g8rswimmer/go-twitter/v2"
+)
+
+// expandURLs replaces the t.co links in text with the URLs they point to and drops links to
+// the tweet's own media, which are already available through Tweet.Media. It returns the
+// rewritten text and the outbound (non-Twitter) URLs found in the entities.
+func expandURLs(text string, entities *twitterv2.EntitiesObj) (string, []string) {
+	if entities == nil {
+		return text, nil
+	}
+
+	var outbound []string
+	for _, entity := range entities.URLs {
+		if entity.URL == "" {
+			continue
+		}
+		if isMediaURL(entity) {
+			text = strings.ReplaceAll(text, entity.URL, "")
+			continue
+		}
+
+		// Prefer the full URL; display_url lacks the scheme and may be truncated.
+		expanded := entity.ExpandedURL
+		if expanded == "" {
+			expanded = entity.DisplayURL
+		}
+		if expanded == "" {
+			continue
+		}
+		text = strings.ReplaceAll(text, entity.URL, expanded)
+		if !isTwitterURL(expanded) {
+			outbound = append(outbound, expanded)
+		}
+	}
+	return strings.TrimSpace(text), outbound
+}
+
+// isMediaURL reports whether a URL entity links to a photo or video attached to the tweet.
+func isMediaURL(entity twitterv2.EntityURLObj) bool {
+	if entity.MediaKey != "" {
+		return true
+	}
+	return isTwitterURL(entity.ExpandedURL) &&
+		(strings.Contains(entity.ExpandedURL, "/photo/") || strings.Contains(entity.ExpandedURL, "/video/"))
+}
+
+// isTwitterURL reports whether rawURL points at twitter.com or x.com.
+func isTwitterURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	return host == "twitter.com" || host == "x.com" || host == "mobile.twitter.com"
+}
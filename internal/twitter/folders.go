@@ -0,0 +1,121 @@
+package twitter
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/korjavin/tw2dynalist/internal/storage"
+)
+
+// tweetLookupBatchSize is the maximum number of IDs accepted by the tweets lookup endpoint.
+const tweetLookupBatchSize = 100
+
+// BookmarkFolder is a named folder the user sorts bookmarks into.
+type BookmarkFolder struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// GetBookmarkFolders lists the authenticated user's bookmark folders.
+func (c *APIClient) GetBookmarkFolders() ([]BookmarkFolder, error) {
+	userID, err := c.user()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bookmark folders: %w", err)
+	}
+	c.logger.Debug("Fetching bookmark folders for user ID: %s", userID)
+	path := fmt.Sprintf("/2/users/%s/bookmarks/folders", userID)
+	params := url.Values{}
+
+	var folders []BookmarkFolder
+	for {
+		var response struct {
+			Data []BookmarkFolder `json:"data"`
+			Meta *tweetsMeta      `json:"meta"`
+		}
+		if err := c.getJSON(path, params, &response); err != nil {
+			return nil, fmt.Errorf("failed to get bookmark folders: %w", err)
+		}
+		folders = append(folders, response.Data...)
+		if response.Meta == nil || response.Meta.NextToken == "" {
+			break
+		}
+		params.Set("pagination_token", response.Meta.NextToken)
+	}
+
+	c.logger.Info("Found %d bookmark folders", len(folders))
+	return folders, nil
+}
+
+// GetFolderBookmarks retrieves the bookmarked tweets in a folder, with Tweet.Folder set to
+// the folder name. If storage is not nil, tweets it has processed are left out before they
+// are looked up, and threads are unrolled for the rest.
+func (c *APIClient) GetFolderBookmarks(folder BookmarkFolder, storage storage.Storage) ([]Tweet, error) {
+	c.logger.Debug("Fetching bookmarks in folder %q", folder.Name)
+	userID, err := c.user()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bookmarks in folder %q: %w", folder.Name, err)
+	}
+	path := fmt.Sprintf("/2/users/%s/bookmarks/folders/%s", userID, folder.ID)
+	params := url.Values{}
+
+	// The folder endpoint only returns tweet IDs, the tweets themselves are looked up separately.
+	var ids []string
+	total := 0
+	for {
+		var response struct {
+			Data []struct {
+				ID string `json:"id"`
+			} `json:"data"`
+			Meta *tweetsMeta `json:"meta"`
+		}
+		if err := c.getJSON(path, params, &response); err != nil {
+			return nil, fmt.Errorf("failed to get bookmarks in folder %q: %w", folder.Name, err)
+		}
+		for _, item := range response.Data {
+			total++
+			if storage == nil || !storage.IsProcessed(item.ID) {
+				ids = append(ids, item.ID)
+			}
+		}
+		if response.Meta == nil || response.Meta.NextToken == "" {
+			break
+		}
+		params.Set("pagination_token", response.Meta.NextToken)
+	}
+
+	tweets, err := c.lookupTweets(ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bookmarks in folder %q: %w", folder.Name, err)
+	}
+	for i := range tweets {
+		tweets[i].Folder = folder.Name
+	}
+	if storage != nil {
+		if _, err := c.unrollThreads(tweets, storage); err != nil {
+			return nil, fmt.Errorf("failed to get bookmarks in folder %q: %w", folder.Name, err)
+		}
+	}
+
+	c.logger.Info("Found %d bookmarks in folder %q, %d not yet processed", total, folder.Name, len(tweets))
+	return tweets, nil
+}
+
+// lookupTweets fetches full tweets for the given IDs in batches.
+func (c *APIClient) lookupTweets(ids []string) ([]Tweet, error) {
+	var tweets []Tweet
+	for start := 0; start < len(ids); start += tweetLookupBatchSize {
+		end := start + tweetLookupBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		params := url.Values{}
+		params.Set("ids", strings.Join(ids[start:end], ","))
+		response, err := c.getTweets("/2/tweets", params)
+		if err != nil {
+			return nil, err
+		}
+		tweets = append(tweets, convertTweets(response)...)
+	}
+	return tweets, nil
+}
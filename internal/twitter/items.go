@@ -0,0 +1,293 @@
+package twitter
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/korjavin/tw2dynalist/internal/config"
+	"github.com/korjavin/tw2dynalist/internal/logger"
+	"github.com/korjavin/tw2dynalist/internal/source"
+	"github.com/korjavin/tw2dynalist/internal/storage"
+)
+
+// Source names, which are also their namespaces in the cache.
+const (
+	BookmarksSourceName = "bookmarks"
+	LikesSourceName     = "likes"
+)
+
+// sinceIDCursor is the cursor key under which List and search sources keep their newest tweet ID.
+const sinceIDCursor = "since_id"
+
+// Cursor keys under which the bookmarks and likes sources keep their TimelineCursor.
+const (
+	newestIDCursor    = "newest_id"
+	fingerprintCursor = "page_fingerprint"
+)
+
+// loadTimelineCursor returns the TimelineCursor kept in store.
+func loadTimelineCursor(store storage.Storage) TimelineCursor {
+	return TimelineCursor{
+		NewestID:    store.GetCursor(newestIDCursor),
+		Fingerprint: store.GetCursor(fingerprintCursor),
+	}
+}
+
+// saveTimelineCursor keeps cursor in store.
+func saveTimelineCursor(store storage.Storage, cursor TimelineCursor) {
+	store.SetCursor(newestIDCursor, cursor.NewestID)
+	store.SetCursor(fingerprintCursor, cursor.Fingerprint)
+}
+
+// referenceLabels describes each referenced tweet type in the Dynalist note.
+var referenceLabels = map[string]string{
+	"quoted":     "Quoted",
+	"retweeted":  "Retweet of",
+	"replied_to": "In reply to",
+}
+
+// Item converts the tweet into a source-neutral item of the named source.
+func (t Tweet) Item(sourceName string) source.Item {
+	item := source.Item{
+		ID:        t.ID,
+		Source:    sourceName,
+		Prefix:    "Tweet",
+		Text:      t.Text,
+		URL:       t.URL,
+		Author:    t.AuthorUsername,
+		CreatedAt: t.CreatedAt,
+		Folder:    t.Folder,
+		Gone:      t.Gone,
+	}
+	for _, media := range t.Media {
+		item.Attachments = append(item.Attachments, source.Attachment{
+			Type:        media.Type,
+			URL:         media.URL,
+			Description: media.AltText,
+		})
+	}
+	for _, ref := range t.Referenced {
+		label, ok := referenceLabels[ref.Type]
+		if !ok {
+			label = "Referenced"
+		}
+		item.References = append(item.References, source.Reference{
+			Label: label,
+			Item:  ref.Tweet.Item(sourceName),
+		})
+	}
+	for _, reply := range t.Thread {
+		item.Children = append(item.Children, reply.Item(sourceName))
+	}
+	return item
+}
+
+// items converts tweets into items of the named source.
+func items(tweets []Tweet, sourceName string) []source.Item {
+	items := make([]source.Item, 0, len(tweets))
+	for _, tweet := range tweets {
+		items = append(items, tweet.Item(sourceName))
+	}
+	return items
+}
+
+// BookmarkSource provides the user's bookmarks, including those in bookmark folders.
+type BookmarkSource struct {
+	client  Client
+	folders []string
+	remove  func() bool
+	logger  *logger.Logger
+	// cursor is the cursor to commit after the last Fetch.
+	cursor TimelineCursor
+}
+
+// NewBookmarkSource creates the bookmarks source. Bookmarks in one of folders are fetched
+// from the folder, so their items carry its name. Saved bookmarks are removed from X
+// while remove returns true.
+func NewBookmarkSource(client Client, folders []string, remove func() bool, logger *logger.Logger) *BookmarkSource {
+	return &BookmarkSource{client: client, folders: folders, remove: remove, logger: logger}
+}
+
+func (s *BookmarkSource) Name() string { return BookmarksSourceName }
+func (s *BookmarkSource) Kind() string { return "Bookmark" }
+
+// Scope returns the OAuth scope the source needs.
+func (s *BookmarkSource) Scope() string { return ScopeBookmarkRead }
+
+// Fetch returns the bookmarks added since the last committed Fetch. If the rate limit is
+// hit, the bookmarks fetched so far are returned along with a *RateLimitError. If the
+// configured folders cannot be read, nothing is returned, so that their bookmarks are not
+// saved to the inbox instead.
+func (s *BookmarkSource) Fetch(store storage.Storage) ([]source.Item, error) {
+	since := loadTimelineCursor(store)
+	tweets, cursor, err := s.client.GetBookmarks(store, since)
+	var rateLimitErr *RateLimitError
+	if err != nil && !errors.As(err, &rateLimitErr) {
+		return nil, err
+	}
+	s.cursor = cursor
+
+	// Bookmarks in folders are in the main list as well, so the folders are left alone
+	// while it is unchanged.
+	if len(s.folders) > 0 && cursor != since {
+		folderTweets, folderErr := s.getFolderBookmarks(store)
+		if folderErr != nil {
+			return nil, folderErr
+		}
+		// The main list's copies of folder bookmarks are dropped, so that a folder bookmark
+		// that fails to save is retried in its folder rather than saved to the inbox.
+		inFolder := make(map[string]bool, len(folderTweets))
+		for _, tweet := range folderTweets {
+			inFolder[tweet.ID] = true
+		}
+		var mainTweets []Tweet
+		for _, tweet := range tweets {
+			if !inFolder[tweet.ID] {
+				mainTweets = append(mainTweets, tweet)
+			}
+		}
+		tweets = append(folderTweets, mainTweets...)
+	}
+	return items(tweets, s.Name()), err
+}
+
+// Commit keeps the cursor of the last Fetch, so the next one stops at the bookmarks it saw.
+func (s *BookmarkSource) Commit(store storage.Storage) {
+	saveTimelineCursor(store, s.cursor)
+}
+
+// Acknowledge removes a saved bookmark from X, if requested.
+func (s *BookmarkSource) Acknowledge(item source.Item) error {
+	if !s.remove() {
+		return nil
+	}
+	return s.client.RemoveBookmark(item.ID)
+}
+
+// getFolderBookmarks fetches the unprocessed bookmarks of every configured folder. Folders
+// that do not exist are logged and skipped.
+func (s *BookmarkSource) getFolderBookmarks(store storage.Storage) ([]Tweet, error) {
+	folders, err := s.client.GetBookmarkFolders()
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := make(map[string]bool, len(s.folders))
+	for _, name := range s.folders {
+		wanted[name] = true
+	}
+
+	var tweets []Tweet
+	found := make(map[string]bool)
+	for _, folder := range folders {
+		if !wanted[folder.Name] {
+			continue
+		}
+		found[folder.Name] = true
+		folderTweets, err := s.client.GetFolderBookmarks(folder, store)
+		if err != nil {
+			return nil, err
+		}
+		tweets = append(tweets, folderTweets...)
+	}
+
+	for _, name := range s.folders {
+		if !found[name] {
+			s.logger.Warn("Bookmark folder %q is configured but does not exist", name)
+		}
+	}
+	return tweets, nil
+}
+
+// LikeSource provides the user's liked tweets. Likes never touch bookmarks.
+type LikeSource struct {
+	client Client
+	// cursor is the cursor to commit after the last Fetch.
+	cursor TimelineCursor
+}
+
+// NewLikeSource creates the liked tweets source.
+func NewLikeSource(client Client) *LikeSource {
+	return &LikeSource{client: client}
+}
+
+func (s *LikeSource) Name() string { return LikesSourceName }
+func (s *LikeSource) Kind() string { return "Like" }
+
+// Scope returns the OAuth scope the source needs.
+func (s *LikeSource) Scope() string { return ScopeLikeRead }
+
+// Fetch returns the tweets liked since the last committed Fetch, paging the same way as
+// BookmarkSource.
+func (s *LikeSource) Fetch(store storage.Storage) ([]source.Item, error) {
+	tweets, cursor, err := s.client.GetLikedTweets(store, loadTimelineCursor(store))
+	s.cursor = cursor
+	return items(tweets, s.Name()), err
+}
+
+// Commit keeps the cursor of the last Fetch, so the next one stops at the likes it saw.
+func (s *LikeSource) Commit(store storage.Storage) {
+	saveTimelineCursor(store, s.cursor)
+}
+
+// TimelineSource provides new tweets from an X List or a recent-search query that match
+// its keywords. It keeps the newest tweet ID it has seen as its cursor.
+type TimelineSource struct {
+	client Client
+	config config.SourceConfig
+	logger *logger.Logger
+	// newest is the cursor to commit after the last Fetch.
+	newest string
+}
+
+// NewTimelineSource creates the source configured by cfg.
+func NewTimelineSource(client Client, cfg config.SourceConfig, logger *logger.Logger) *TimelineSource {
+	return &TimelineSource{client: client, config: cfg, logger: logger}
+}
+
+func (s *TimelineSource) Name() string { return s.config.Name() }
+
+func (s *TimelineSource) Kind() string {
+	if s.config.Type == config.SourceTypeList {
+		return "List Tweet"
+	}
+	return "Search Result"
+}
+
+// Scope returns the OAuth scope the source needs.
+func (s *TimelineSource) Scope() string {
+	if s.config.Type == config.SourceTypeList {
+		return ScopeListRead
+	}
+	return ScopeTweetRead
+}
+
+// Fetch returns the tweets newer than the cursor that match the keywords.
+func (s *TimelineSource) Fetch(store storage.Storage) ([]source.Item, error) {
+	sinceID := store.GetCursor(sinceIDCursor)
+
+	var tweets []Tweet
+	var err error
+	switch s.config.Type {
+	case config.SourceTypeList:
+		tweets, err = s.client.GetListTweets(s.config.Target, sinceID)
+	case config.SourceTypeSearch:
+		tweets, err = s.client.SearchRecentTweets(s.config.Target, sinceID)
+	default:
+		err = fmt.Errorf("unknown source type %q", s.config.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.newest = NewestID(tweets, sinceID)
+	matching := source.FilterByKeywords(items(tweets, s.Name()), s.config.Keywords)
+	s.logger.Info("Source %s returned %d new tweets, %d matching keywords", s.Name(), len(tweets), len(matching))
+	return matching, nil
+}
+
+// Commit moves the cursor past the tweets of the last Fetch, including those that did not
+// match the keywords.
+func (s *TimelineSource) Commit(store storage.Storage) {
+	store.SetCursor(sinceIDCursor, s.newest)
+}
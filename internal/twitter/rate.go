@@ -0,0 +1,74 @@
+package twitter
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/korjavin/tw2dynalist/internal/apierr"
+)
+
+// defaultRateLimitWindow is assumed when a 429 response carries no x-rate-limit-reset header.
+const defaultRateLimitWindow = 15 * time.Minute
+
+// RateLimitError is returned when the rate limit of an endpoint is exhausted.
+type RateLimitError = apierr.RateLimitError
+
+// RateLimit is the request budget of an endpoint as reported by the x-rate-limit headers.
+type RateLimit struct {
+	Limit     int
+	Remaining int
+	Reset     time.Time
+}
+
+// parseRateLimit reads the x-rate-limit headers, returning false if they are missing.
+func parseRateLimit(header http.Header) (RateLimit, bool) {
+	limit, err := strconv.Atoi(header.Get("x-rate-limit-limit"))
+	if err != nil {
+		return RateLimit{}, false
+	}
+	remaining, err := strconv.Atoi(header.Get("x-rate-limit-remaining"))
+	if err != nil {
+		return RateLimit{}, false
+	}
+	reset, err := strconv.ParseInt(header.Get("x-rate-limit-reset"), 10, 64)
+	if err != nil {
+		return RateLimit{}, false
+	}
+	return RateLimit{Limit: limit, Remaining: remaining, Reset: time.Unix(reset, 0)}, true
+}
+
+// checkRateLimit records the rate limit headers of a response and returns a *RateLimitError
+// if the response is a 429.
+func (c *APIClient) checkRateLimit(path string, resp *http.Response) error {
+	endpoint := endpointName(path)
+	rl, ok := parseRateLimit(resp.Header)
+	if ok {
+		c.recorder().RecordRateLimit(endpoint, rl)
+		if rl.Remaining == 0 {
+			c.logger.Warn("Rate limit budget for %s is exhausted until %s", endpoint, rl.Reset.Format(time.RFC3339))
+		}
+	}
+	if resp.StatusCode != http.StatusTooManyRequests {
+		return nil
+	}
+
+	reset := time.Now().Add(defaultRateLimitWindow)
+	if ok && rl.Reset.After(time.Now()) {
+		reset = rl.Reset
+	}
+	return &RateLimitError{Endpoint: endpoint, Reset: reset}
+}
+
+// endpointName replaces the IDs in an API path with ":id", so that metrics are kept per
+// endpoint rather than per user, list or tweet.
+func endpointName(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if _, err := strconv.ParseUint(segment, 10, 64); err == nil && i > 1 {
+			segments[i] = ":id"
+		}
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,79 @@
+package twitter
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// GetListTweets retrieves tweets from a List that are newer than sinceID, newest first.
+// The List endpoint has no since_id parameter, so paging stops at the first known tweet.
+// Without a sinceID only the first page is fetched, to avoid backfilling the whole List.
+func (c *APIClient) GetListTweets(listID, sinceID string) ([]Tweet, error) {
+	c.logger.Info("Fetching tweets for list %s since %q", listID, sinceID)
+	path := fmt.Sprintf("/2/lists/%s/tweets", listID)
+	params := url.Values{}
+	params.Set("max_results", strconv.Itoa(timelinePageSize))
+
+	var tweets []Tweet
+	for {
+		response, err := c.getTweets(path, params)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get tweets for list %s: %w", listID, scopeError(err, ScopeListRead))
+		}
+		for _, tweet := range convertTweets(response) {
+			if sinceID != "" && !lessID(sinceID, tweet.ID) {
+				c.logger.Info("Found %d new tweets in list %s", len(tweets), listID)
+				return tweets, nil
+			}
+			tweets = append(tweets, tweet)
+		}
+		if sinceID == "" || response.Meta == nil || response.Meta.NextToken == "" {
+			break
+		}
+		params.Set("pagination_token", response.Meta.NextToken)
+	}
+
+	c.logger.Info("Found %d new tweets in list %s", len(tweets), listID)
+	return tweets, nil
+}
+
+// SearchRecentTweets retrieves tweets from the last seven days matching query that are newer
+// than sinceID, newest first. Without a sinceID only the first page is fetched.
+func (c *APIClient) SearchRecentTweets(query, sinceID string) ([]Tweet, error) {
+	c.logger.Info("Searching recent tweets for %q since %q", query, sinceID)
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("max_results", strconv.Itoa(timelinePageSize))
+	if sinceID != "" {
+		params.Set("since_id", sinceID)
+	}
+
+	var tweets []Tweet
+	for {
+		response, err := c.getTweets("/2/tweets/search/recent", params)
+		if err != nil {
+			return nil, fmt.Errorf("failed to search tweets for %q: %w", query, err)
+		}
+		tweets = append(tweets, convertTweets(response)...)
+		if sinceID == "" || response.Meta == nil || response.Meta.NextToken == "" {
+			break
+		}
+		params.Set("next_token", response.Meta.NextToken)
+	}
+
+	c.logger.Info("Found %d new tweets for search %q", len(tweets), query)
+	return tweets, nil
+}
+
+// NewestID returns the highest tweet ID in tweets, or fallback if tweets is empty or
+// contains nothing newer.
+func NewestID(tweets []Tweet, fallback string) string {
+	newest := fallback
+	for _, tweet := range tweets {
+		if newest == "" || lessID(newest, tweet.ID) {
+			newest = tweet.ID
+		}
+	}
+	return newest
+}
@@ -1,14 +1,22 @@
 package twitter
 
 import (
-	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/korjavin/tw2dynalist/internal/apierr"
 	"github.com/korjavin/tw2dynalist/internal/auth"
 	"github.com/korjavin/tw2dynalist/internal/config"
 	"github.com/korjavin/tw2dynalist/internal/logger"
@@ -20,18 +28,118 @@ import (
 
 // Tweet represents a simplified tweet structure.
 type Tweet struct {
-	ID   string
-	Text string
-	URL  string
+	ID             string
+	Text           string
+	URL            string
+	AuthorID       string
+	AuthorUsername string
+	ConversationID string
+	InReplyToUser  string
+	CreatedAt      time.Time
+	// ReplyCount is the number of replies to the tweet, or -1 if it is not known.
+	ReplyCount int
+	// Thread holds the author's own follow-up tweets, oldest first, when this tweet starts a thread.
+	Thread []Tweet
+	// Referenced holds the tweets this tweet quotes, retweets or replies to.
+	Referenced []ReferencedTweet
+	Media      []Media
+	// URLs lists the outbound links in the tweet, with t.co redirects already resolved.
+	URLs []string
+	// Folder is the name of the bookmark folder the tweet was found in, if any.
+	Folder string
+	// Gone, if not empty, says why the tweet can no longer be read, such as GoneDeleted.
+	// Such a tweet has nothing but its ID and URL.
+	Gone string
 }
 
+// Reasons a tweet is gone from X.
+const (
+	GoneDeleted     = "deleted on X"
+	GoneProtected   = "protected on X"
+	GoneUnavailable = "unavailable on X"
+)
+
+// goneReasons maps the problem types of partial errors, the last segment of their type
+// URI, onto the reason a tweet is gone.
+var goneReasons = map[string]string{
+	"resource-not-found":          GoneDeleted,
+	"not-authorized-for-resource": GoneProtected,
+	"resource-unavailable":        GoneUnavailable,
+}
+
+// Media is a photo, video or GIF attached to a tweet.
+type Media struct {
+	// Type is one of "photo", "video" or "animated_gif".
+	Type    string
+	URL     string
+	AltText string
+}
+
+// ReferencedTweet is a tweet referred to by another tweet.
+type ReferencedTweet struct {
+	// Type is one of "quoted", "retweeted" or "replied_to".
+	Type  string
+	Tweet Tweet
+}
+
+// IsThreadStart reports whether the tweet is the first tweet of its conversation.
+func (t Tweet) IsThreadStart() bool {
+	return t.ConversationID != "" && t.ConversationID == t.ID
+}
+
+// MayStartThread reports whether the tweet may start a thread worth looking up: it starts
+// its conversation, which every tweet that is not a reply does, and has replies, unless
+// their number is not known.
+func (t Tweet) MayStartThread() bool {
+	return t.IsThreadStart() && t.ReplyCount != 0
+}
+
+// timelinePageSize is the maximum page size allowed by the bookmarks and likes endpoints.
+const timelinePageSize = 100
+
 // Client defines the interface for interacting with the Twitter API.
 type Client interface {
-	GetBookmarks() ([]Tweet, error)
+	GetBookmarks(storage storage.Storage, since TimelineCursor) ([]Tweet, TimelineCursor, error)
+	GetBookmarkFolders() ([]BookmarkFolder, error)
+	GetFolderBookmarks(folder BookmarkFolder, storage storage.Storage) ([]Tweet, error)
+	GetLikedTweets(storage storage.Storage, since TimelineCursor) ([]Tweet, TimelineCursor, error)
+	GetListTweets(listID, sinceID string) ([]Tweet, error)
+	SearchRecentTweets(query, sinceID string) ([]Tweet, error)
 	RemoveBookmark(tweetID string) error
 	CleanupProcessedBookmarks(storage storage.Storage) error
+	// Authorized reports whether the user has authorized the app; until then every
+	// request fails with ErrNotAuthorized.
+	Authorized() bool
+	// OnAuthorized registers a function to call once the user completes authorization.
+	OnAuthorized(fn func())
+	// GrantedScopes returns the OAuth scopes the user granted, or nil if they are not known.
+	GrantedScopes() []string
+	// Logout revokes the token, deletes the token file and returns to the unauthorized state.
+	Logout() error
+	Close()
 }
 
+// Recorder receives progress updates from the client, typically for display on the dashboard.
+type Recorder interface {
+	RecordBookmarkPage(page, tweets, unseen int)
+	RecordRateLimit(endpoint string, rl RateLimit)
+	// RecordAPICall reports a request sent to the API endpoint.
+	RecordAPICall(endpoint string)
+	// RecordTokenExpiry reports when the current OAuth token expires.
+	RecordTokenExpiry(expiresAt time.Time)
+	// RecordTokenRefresh reports a refreshed OAuth token and its expiry.
+	RecordTokenRefresh(expiresAt time.Time)
+}
+
+// nopRecorder is used when no Recorder has been configured.
+type nopRecorder struct{}
+
+func (nopRecorder) RecordBookmarkPage(page, tweets, unseen int)   {}
+func (nopRecorder) RecordRateLimit(endpoint string, rl RateLimit) {}
+func (nopRecorder) RecordAPICall(endpoint string)                 {}
+func (nopRecorder) RecordTokenExpiry(expiresAt time.Time)         {}
+func (nopRecorder) RecordTokenRefresh(expiresAt time.Time)        {}
+
 // APIClient implements the Client interface for the Twitter API.
 type APIClient struct {
 	client       *twitterv2.Client
@@ -39,269 +147,531 @@ type APIClient struct {
 	logger       *logger.Logger
 	config       *config.Config
 	oauth2Config *oauth2.Config
-	token        *oauth2.Token
+	revokeURL    string
+	tokens       *auth.TokenManager
+	metrics      Recorder
+
+	// authMu guards userID and the state of the OAuth flows below.
+	authMu       sync.Mutex
+	authFlows    map[string]authFlow
+	onAuthorized func()
 }
 
-// NewClient creates a new Twitter API client.
-func NewClient(cfg *config.Config, logger *logger.Logger, mux *http.ServeMux) (*APIClient, error) {
-	logger.Debug("Creating OAuth2 configuration")
-
-	oauth2Config := &oauth2.Config{
-		ClientID:     cfg.TwitterClientID,
-		ClientSecret: cfg.TwitterClientSecret,
-		RedirectURL:  cfg.TwitterRedirectURL,
-		Endpoint: oauth2.Endpoint{
-			AuthURL:  "https://twitter.com/i/oauth2/authorize",
-			TokenURL: "https://api.twitter.com/2/oauth2/token",
-		},
-		Scopes: []string{"tweet.read", "users.read", "bookmark.read", "bookmark.write", "offline.access"},
+// SetRecorder sets the Recorder that receives progress updates.
+func (c *APIClient) SetRecorder(r Recorder) {
+	c.metrics = r
+	if c.tokens != nil && c.Authorized() {
+		r.RecordTokenExpiry(c.tokens.Token().Expiry)
 	}
+}
 
-	logger.Debug("OAuth2 redirect URL: %s", cfg.TwitterRedirectURL)
+// Close stops refreshing the OAuth token in the background.
+func (c *APIClient) Close() {
+	c.tokens.Stop()
+}
+
+func (c *APIClient) recorder() Recorder {
+	if c.metrics == nil {
+		return nopRecorder{}
+	}
+	return c.metrics
+}
 
+// NewClient creates a new Twitter API client for the account of cfg, whose OAuth flows
+// are served by router.
+func NewClient(cfg *config.Config, logger *logger.Logger, router *Router) (*APIClient, error) {
+	store := auth.NewTokenStore(cfg.TokenFilePath, cfg.TokenEncryptionKey, logger)
 	var token *oauth2.Token
 	var userID string
-	var err error
-
 	if _, statErr := os.Stat(cfg.TokenFilePath); os.IsNotExist(statErr) {
 		logger.Info("No token file found at %s", cfg.TokenFilePath)
-
-		codeVerifier, err := auth.GenerateCodeVerifier()
-		if err != nil {
-			return nil, fmt.Errorf("failed to generate code verifier: %v", err)
-		}
-		codeChallenge := auth.GenerateCodeChallenge(codeVerifier)
-
-		codeChan := make(chan string, 1)
-		stateChan := make(chan string, 1)
-		errorChan := make(chan error, 1)
-
-		setupCallbackHandler(mux, codeChan, stateChan, errorChan, logger)
-
-		authURL := auth.GetAuthURL(oauth2Config, "state", codeChallenge)
-		logger.Info("Please visit the following URL to authorize this application:")
-		logger.Info("%s", authURL)
-
-		var code string
-		select {
-		case code = <-codeChan:
-			logger.Debug("Received authorization code from callback")
-		case err := <-errorChan:
-			return nil, fmt.Errorf("OAuth callback error: %v", err)
-		case <-time.After(30 * time.Minute):
-			return nil, fmt.Errorf("OAuth authorization timeout after 30 minutes")
-		}
-
-		token, err = auth.ExchangeToken(oauth2Config, code, codeVerifier)
-		if err != nil {
-			return nil, fmt.Errorf("failed to exchange token: %v", err)
-		}
-
-		if err := auth.SaveToken(cfg.TokenFilePath, token, ""); err != nil {
-			logger.Warn("Failed to save token: %v", err)
-		}
 	} else {
-		token, userID, err = auth.LoadToken(cfg.TokenFilePath)
+		var err error
+		token, userID, err = store.LoadToken()
 		if err != nil {
-			return nil, fmt.Errorf("failed to load token: %v", err)
+			logger.Error("Failed to load token: %v", err)
+			token = nil
+		} else {
+			logger.Debug("Loaded token with userID: %s", userID)
 		}
-		logger.Debug("Loaded token with userID: %s", userID)
 	}
 
-	authorizer := auth.NewAuthorizer(token)
+	apiClient := newAPIClient(cfg, logger, store, token, userID)
+	router.add(apiClient)
+	apiClient.tokens.Start()
 
-	client := &twitterv2.Client{
-		Authorizer: authorizer,
-		Client:     &http.Client{Timeout: 10 * time.Second},
-		Host:       "https://api.twitter.com",
+	if !apiClient.Authorized() {
+		logger.Warn("Twitter account not authorized yet, open the status dashboard and follow the \"Authorize with X\" link")
+		return apiClient, nil
 	}
 
-	if userID == "" || userID == "me" {
-		logger.Info("Need to lookup actual user ID for bookmarks API")
-		userOpts := twitterv2.UserLookupOpts{
-			UserFields: []twitterv2.UserField{twitterv2.UserFieldID},
-		}
-		cleanUsername := strings.TrimPrefix(cfg.TwitterUsername, "@")
-		userResponse, err := client.UserNameLookup(context.Background(), []string{cleanUsername}, userOpts)
-		if err != nil {
-			return nil, fmt.Errorf("cannot get user ID for bookmarks API: %v", err)
-		}
-		if userResponse.Raw == nil || len(userResponse.Raw.Users) == 0 || userResponse.Raw.Users[0] == nil {
-			return nil, fmt.Errorf("failed to get user information for bookmarks API")
-		}
-		userID = userResponse.Raw.Users[0].ID
-		logger.Info("Successfully retrieved user ID: %s", userID)
-		if err := auth.SaveToken(cfg.TokenFilePath, token, userID); err != nil {
-			logger.Warn("Failed to save token with user info: %v", err)
+	// The user ID in the token file is only trusted if the token cannot be checked, as it
+	// may belong to an earlier authorization.
+	cachedUserID := apiClient.userID
+	if cachedUserID == "me" {
+		cachedUserID = ""
+	}
+	userID, err := apiClient.lookupUserID()
+	switch {
+	case err == nil:
+		if userID != cachedUserID {
+			if cachedUserID != "" {
+				logger.Warn("Token file has user ID %s, but the token belongs to user ID %s, updating the token file", cachedUserID, userID)
+			}
+			apiClient.setUser(userID)
 		}
-	} else {
-		logger.Info("Using cached user ID: %s", userID)
+	case errors.Is(err, ErrNotAuthorized) || errors.Is(err, apierr.ErrInvalidToken):
+		logger.Error("%v", err)
+		logger.Warn("Authorize again from the status dashboard to continue")
+		apiClient.tokens.SetToken(nil)
+	case cachedUserID != "":
+		logger.Warn("Failed to verify the user ID, using cached user ID %s: %v", cachedUserID, err)
+	default:
+		// The token is kept, the user is looked up again on the next request.
+		logger.Warn("Failed to look up the authorized user, retrying on the next run: %v", err)
 	}
+	return apiClient, nil
+}
+
+// newAPIClient creates a client for token, which may be nil, without starting the
+// background token refresh.
+func newAPIClient(cfg *config.Config, logger *logger.Logger, store *auth.TokenStore, token *oauth2.Token, userID string) *APIClient {
+	logger.Debug("Creating OAuth2 configuration")
+
+	oauth2Config := &oauth2.Config{
+		ClientID:     cfg.TwitterClientID,
+		ClientSecret: cfg.TwitterClientSecret,
+		RedirectURL:  cfg.TwitterRedirectURL,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  cfg.TwitterAuthURL,
+			TokenURL: cfg.TwitterTokenURL,
+		},
+		Scopes: []string{ScopeTweetRead, ScopeUsersRead, ScopeBookmarkRead, ScopeBookmarkWrite, ScopeLikeRead, ScopeListRead, ScopeOfflineAccess},
+	}
+
+	logger.Debug("OAuth2 redirect URL: %s", cfg.TwitterRedirectURL)
 
 	apiClient := &APIClient{
-		client:       client,
 		userID:       userID,
 		logger:       logger,
 		config:       cfg,
 		oauth2Config: oauth2Config,
-		token:        token,
+		revokeURL:    cfg.TwitterRevokeURL,
+		tokens:       auth.NewTokenManager(oauth2Config, token, store, userID, logger),
 	}
-	return apiClient, nil
+	apiClient.tokens.OnRefresh(func(token *oauth2.Token) {
+		apiClient.recorder().RecordTokenRefresh(token.Expiry)
+	})
+	apiClient.client = &twitterv2.Client{
+		Authorizer: apiClient.tokens,
+		Client:     &http.Client{Timeout: 10 * time.Second},
+		Host:       cfg.TwitterAPIURL,
+	}
+	return apiClient
 }
 
-func (c *APIClient) refreshToken() error {
-	c.logger.Info("Attempting to refresh token")
-	if c.token.RefreshToken == "" {
-		return fmt.Errorf("no refresh token available")
-	}
+// TimelineCursor records how far a timeline was read, so that the next read can stop as
+// soon as it reaches tweets it has seen before.
+type TimelineCursor struct {
+	// NewestID is the ID of the first tweet in the timeline.
+	NewestID string
+	// Fingerprint identifies the tweets on the first page.
+	Fingerprint string
+}
 
-	newToken, err := c.oauth2Config.TokenSource(context.Background(), c.token).Token()
+// GetBookmarks retrieves bookmarked tweets for the authenticated user, following
+// pagination tokens until the list is exhausted. If storage is not nil, paging stops
+// as soon as a whole page consists of tweets that are already processed, or the first
+// tweet of since is reached. If the first page is unchanged since then, no tweets are
+// returned at all. Along with the tweets, the cursor to pass on the next call is returned.
+// If the rate limit is hit, the tweets fetched so far are returned along with a
+// *RateLimitError.
+func (c *APIClient) GetBookmarks(storage storage.Storage, since TimelineCursor) ([]Tweet, TimelineCursor, error) {
+	userID, err := c.user()
 	if err != nil {
-		return fmt.Errorf("failed to refresh token: %v", err)
+		return nil, since, fmt.Errorf("failed to get bookmarks: %w", err)
 	}
+	c.logger.Info("Fetching bookmarks for user ID: %s", userID)
+	path := fmt.Sprintf("/2/users/%s/bookmarks", userID)
+	return c.getTimeline("bookmarks", path, storage, since, c.recorder().RecordBookmarkPage)
+}
 
-	c.logger.Info("Token refreshed successfully")
-	if err := auth.SaveToken(c.config.TokenFilePath, newToken, c.userID); err != nil {
-		c.logger.Warn("Failed to save refreshed token: %v", err)
+// GetLikedTweets retrieves tweets liked by the authenticated user, paging the same way as GetBookmarks.
+func (c *APIClient) GetLikedTweets(storage storage.Storage, since TimelineCursor) ([]Tweet, TimelineCursor, error) {
+	userID, err := c.user()
+	if err != nil {
+		return nil, since, fmt.Errorf("failed to get liked tweets: %w", err)
 	}
-
-	c.token = newToken
-	c.client.Authorizer = auth.NewAuthorizer(c.token)
-	return nil
+	c.logger.Info("Fetching liked tweets for user ID: %s", userID)
+	path := fmt.Sprintf("/2/users/%s/liked_tweets", userID)
+	tweets, cursor, err := c.getTimeline("liked tweets", path, storage, since, nil)
+	return tweets, cursor, scopeError(err, ScopeLikeRead)
 }
 
-// GetBookmarks retrieves bookmarked tweets for the authenticated user.
-func (c *APIClient) GetBookmarks() ([]Tweet, error) {
-	c.logger.Info("Fetching bookmarks for user ID: %s", c.userID)
-	opts := twitterv2.TweetBookmarksLookupOpts{
-		MaxResults: 100,
-		TweetFields: []twitterv2.TweetField{
-			twitterv2.TweetFieldID,
-			twitterv2.TweetFieldText,
-			twitterv2.TweetFieldAuthorID,
-			twitterv2.TweetFieldCreatedAt,
-		},
-		UserFields: []twitterv2.UserField{
-			twitterv2.UserFieldID,
-			twitterv2.UserFieldName,
-			twitterv2.UserFieldUserName,
-		},
-		Expansions: []twitterv2.Expansion{
-			twitterv2.ExpansionAuthorID,
-		},
+// getTimeline pages through an endpoint returning a list of tweets, newest first. name is
+// used in log messages, and onPage, if not nil, is called after every page. On a rate limit
+// the pages fetched so far are returned together with the error.
+func (c *APIClient) getTimeline(name, path string, storage storage.Storage, since TimelineCursor, onPage func(page, tweets, unseen int)) ([]Tweet, TimelineCursor, error) {
+	if onPage == nil {
+		onPage = func(page, tweets, unseen int) {}
 	}
+	params := url.Values{}
+	params.Set("max_results", strconv.Itoa(timelinePageSize))
 
-	bookmarksResponse, err := c.client.TweetBookmarksLookup(context.Background(), c.userID, opts)
-	if err != nil {
-		if strings.Contains(err.Error(), "401") {
-			c.logger.Warn("Received 401 Unauthorized, attempting to refresh token")
-			if err := c.refreshToken(); err != nil {
-				c.logger.Error("Failed to refresh token: %v", err)
-				if _, statErr := os.Stat(c.config.TokenFilePath); !os.IsNotExist(statErr) {
-					if removeErr := os.Remove(c.config.TokenFilePath); removeErr != nil {
-						c.logger.Error("Failed to remove token file: %v", removeErr)
-					} else {
-						c.logger.Info("Removed token file, please re-authenticate")
-					}
-				}
-				return nil, fmt.Errorf("failed to refresh token, re-authentication required: %v", err)
+	var tweets []Tweet
+	cursor := since
+	for page := 1; ; page++ {
+		response, err := c.getTweets(path, params)
+		if err != nil {
+			var rateLimitErr *RateLimitError
+			if errors.As(err, &rateLimitErr) {
+				c.logger.Warn("Twitter API rate limit hit on %s endpoint (page %d), resets at %s", name, page, rateLimitErr.Reset.Format(time.RFC3339))
+				return tweets, cursor, fmt.Errorf("failed to get %s: %w", name, err)
 			}
-			c.logger.Info("Retrying to get bookmarks after token refresh")
-			bookmarksResponse, err = c.client.TweetBookmarksLookup(context.Background(), c.userID, opts)
+			return nil, since, fmt.Errorf("failed to get %s: %w", name, err)
 		}
-		if err != nil {
-			var twitterErr *twitterv2.ErrorResponse
-			if errors.As(err, &twitterErr) {
-				if twitterErr.StatusCode == 429 {
-					c.logger.Warn("Twitter API rate limit hit on bookmarks endpoint. Original message: %s", twitterErr.Detail)
-					return []Tweet{}, nil
+
+		// Deleted and protected tweets are listed among the errors rather than the data.
+		if gone := goneTweets(response); len(gone) > 0 {
+			c.logger.Info("Page %d of %s lists %d tweets that are gone from X", page, name, len(gone))
+			tweets = append(tweets, gone...)
+		}
+
+		if len(response.Tweets) == 0 {
+			c.logger.Info("Page %d of %s is empty", page, name)
+			onPage(page, 0, 0)
+			break
+		}
+
+		if page == 1 {
+			cursor = TimelineCursor{NewestID: response.Tweets[0].ID, Fingerprint: pageFingerprint(response.Tweets)}
+			if storage != nil && since.Fingerprint != "" && cursor.Fingerprint == since.Fingerprint {
+				c.logger.Info("First page of %s is unchanged since the last run, stopping pagination", name)
+				onPage(page, len(response.Tweets), 0)
+				// The fingerprint only covers the tweets X still returns, so the page may
+				// list tweets that are gone since.
+				return tweets, cursor, nil
+			}
+		}
+
+		pageTweets := convertTweets(response)
+		// Tweets from the newest one of the last run on were all seen before.
+		known := false
+		if storage != nil && since.NewestID != "" {
+			for i, tweet := range pageTweets {
+				if tweet.ID == since.NewestID {
+					pageTweets, known = pageTweets[:i], true
+					break
 				}
 			}
-			return nil, fmt.Errorf("failed to get bookmarks: %v", err)
 		}
+		unseen := 0
+		for _, tweet := range pageTweets {
+			if storage == nil || !storage.IsProcessed(tweet.ID) {
+				unseen++
+			}
+		}
+		if storage != nil {
+			if unrolled, err := c.unrollThreads(pageTweets, storage); err != nil {
+				c.logger.Warn("Twitter API rate limit hit looking up threads on page %d of %s", page, name)
+				return append(tweets, pageTweets[:unrolled]...), cursor, fmt.Errorf("failed to get %s: %w", name, err)
+			}
+		}
+		tweets = append(tweets, pageTweets...)
+
+		c.logger.Info("Page %d of %s: %d tweets, %d not yet processed", page, name, len(pageTweets), unseen)
+		onPage(page, len(pageTweets), unseen)
+
+		if known {
+			c.logger.Info("Reached the newest %s of the last run on page %d, stopping pagination", name, page)
+			break
+		}
+		if storage != nil && unseen == 0 {
+			c.logger.Info("All %s on page %d are already processed, stopping pagination", name, page)
+			break
+		}
+		if response.Meta == nil || response.Meta.NextToken == "" {
+			break
+		}
+		params.Set("pagination_token", response.Meta.NextToken)
 	}
 
-	if bookmarksResponse.Raw == nil || len(bookmarksResponse.Raw.Tweets) == 0 {
-		c.logger.Info("Found 0 bookmarked tweets")
-		return []Tweet{}, nil
+	c.logger.Info("Found %d %s", len(tweets), name)
+	return tweets, cursor, nil
+}
+
+// pageFingerprint identifies a page of tweets by their IDs, in order.
+func pageFingerprint(tweets []*tweetObj) string {
+	h := sha256.New()
+	for _, tweet := range tweets {
+		io.WriteString(h, tweet.ID)
+		h.Write([]byte{0})
 	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
 
-	c.logger.Info("Found %d bookmarks", len(bookmarksResponse.Raw.Tweets))
+// goneTweets returns the tweets a response lists as deleted, protected or otherwise
+// unavailable. Errors about expanded objects, such as a deleted quoted tweet, are left out.
+func goneTweets(raw *tweetsResponse) []Tweet {
+	var tweets []Tweet
+	for _, e := range raw.Errors {
+		reason, ok := goneReasons[path.Base(e.Type)]
+		if !ok || e.ResourceType != "tweet" || strings.Contains(e.Parameter, ".") {
+			continue
+		}
+		id := e.ResourceID
+		if id == "" {
+			id, _ = e.Value.(string)
+		}
+		if id == "" {
+			continue
+		}
+		tweets = append(tweets, Tweet{
+			ID:   id,
+			URL:  "https://twitter.com/i/web/status/" + id,
+			Gone: reason,
+		})
+	}
+	return tweets
+}
 
+// convertTweets maps a raw API response onto the simplified Tweet structure.
+func convertTweets(raw *tweetsResponse) []Tweet {
 	authorMap := make(map[string]string)
-	if bookmarksResponse.Raw.Includes != nil {
-		for _, user := range bookmarksResponse.Raw.Includes.Users {
+	includedTweets := make(map[string]*tweetObj)
+	includedMedia := make(map[string]*twitterv2.MediaObj)
+	if raw.Includes != nil {
+		for _, user := range raw.Includes.Users {
 			authorMap[user.ID] = user.UserName
 		}
+		for _, tweet := range raw.Includes.Tweets {
+			includedTweets[tweet.ID] = tweet
+		}
+		for _, media := range raw.Includes.Media {
+			includedMedia[media.Key] = media
+		}
 	}
 
 	var tweets []Tweet
-	for _, tweet := range bookmarksResponse.Raw.Tweets {
-		username := "user"
-		if authorUsername, ok := authorMap[tweet.AuthorID]; ok {
-			username = authorUsername
+	for _, obj := range raw.Tweets {
+		tweet := newTweet(obj, authorMap)
+		for _, ref := range obj.ReferencedTweets {
+			refObj, ok := includedTweets[ref.ID]
+			if !ok {
+				// Deleted or protected tweets are not included in the response.
+				continue
+			}
+			tweet.Referenced = append(tweet.Referenced, ReferencedTweet{
+				Type:  ref.Type,
+				Tweet: newTweet(refObj, authorMap),
+			})
 		}
-		tweetURL := fmt.Sprintf("https://twitter.com/%s/status/%s", username, tweet.ID)
-		tweets = append(tweets, Tweet{
-			ID:   tweet.ID,
-			Text: tweet.Text,
-			URL:  tweetURL,
-		})
+		if obj.Attachments != nil {
+			for _, key := range obj.Attachments.MediaKeys {
+				media, ok := includedMedia[key]
+				if !ok {
+					continue
+				}
+				// Videos and GIFs have no direct URL, only a preview image.
+				mediaURL := media.URL
+				if mediaURL == "" {
+					mediaURL = media.PreviewImageURL
+				}
+				tweet.Media = append(tweet.Media, Media{
+					Type:    media.Type,
+					URL:     mediaURL,
+					AltText: media.AltText,
+				})
+			}
+		}
+		tweets = append(tweets, tweet)
+	}
+	return tweets
+}
+
+// newTweet converts a single tweet object, resolving its author from authorMap.
+func newTweet(obj *tweetObj, authorMap map[string]string) Tweet {
+	username := "user"
+	if authorUsername, ok := authorMap[obj.AuthorID]; ok {
+		username = authorUsername
+	}
+	// Long-form tweets carry a truncated Text; the full body and its entities are in note_tweet.
+	text, entities := obj.Text, obj.Entities
+	if obj.NoteTweet != nil && obj.NoteTweet.Text != "" {
+		text, entities = obj.NoteTweet.Text, obj.NoteTweet.Entities
+	}
+	text, urls := expandURLs(text, entities)
+	// The creation time is informational, so a missing or malformed one is left zero.
+	createdAt, _ := time.Parse(time.RFC3339, obj.CreatedAt)
+	replyCount := -1
+	if obj.PublicMetrics != nil {
+		replyCount = obj.PublicMetrics.Replies
+	}
+	return Tweet{
+		ID:             obj.ID,
+		Text:           text,
+		URL:            fmt.Sprintf("https://twitter.com/%s/status/%s", username, obj.ID),
+		URLs:           urls,
+		AuthorID:       obj.AuthorID,
+		AuthorUsername: authorMap[obj.AuthorID],
+		ConversationID: obj.ConversationID,
+		InReplyToUser:  obj.InReplyToUserID,
+		CreatedAt:      createdAt,
+		ReplyCount:     replyCount,
 	}
-	return tweets, nil
+}
+
+// recentSearchWindow is how far back the recent search endpoint, which threads are looked
+// up with, reaches.
+const recentSearchWindow = 7 * 24 * time.Hour
+
+// unrollThreads attaches the follow-up tweets to every unprocessed tweet that starts a thread.
+// Each lookup costs a recent search request, so tweets without replies, which cannot start
+// a thread, are not looked up. If the rate limit is hit, it stops and returns the *RateLimitError along with the number
+// of tweets that were handled; the rest must not be saved without their threads.
+func (c *APIClient) unrollThreads(tweets []Tweet, storage storage.Storage) (int, error) {
+	if !c.config.UnrollThreads {
+		return len(tweets), nil
+	}
+	for i, tweet := range tweets {
+		if tweet.MayStartThread() && !storage.IsProcessed(tweet.ID) {
+			thread, err := c.getThread(tweet)
+			if err != nil {
+				return i, err
+			}
+			tweets[i].Thread = thread
+		}
+	}
+	return len(tweets), nil
+}
+
+// getThread collects the author's own replies in the conversation started by head, oldest
+// first. Recent search only covers the last 7 days, so older threads are not looked up and
+// saved without their follow-ups. A rate limit is returned as a *RateLimitError; other
+// lookup failures are logged and produce an empty thread, so the head tweet is still saved.
+func (c *APIClient) getThread(head Tweet) ([]Tweet, error) {
+	if head.AuthorUsername == "" {
+		c.logger.Debug("Unknown author for tweet %s, not unrolling thread", head.ID)
+		return nil, nil
+	}
+	if !head.CreatedAt.IsZero() && time.Since(head.CreatedAt) > recentSearchWindow {
+		c.logger.Info("Tweet %s is older than the 7 days recent search covers, saving it without its thread", head.ID)
+		return nil, nil
+	}
+
+	query := fmt.Sprintf("conversation_id:%s from:%s", head.ConversationID, head.AuthorUsername)
+	c.logger.Debug("Looking up thread with query: %s", query)
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("max_results", "100")
+
+	var thread []Tweet
+	for {
+		searchResponse, err := c.getTweets("/2/tweets/search/recent", params)
+		if err != nil {
+			var rateLimitErr *RateLimitError
+			if errors.As(err, &rateLimitErr) {
+				return nil, fmt.Errorf("failed to look up thread for tweet %s: %w", head.ID, err)
+			}
+			c.logger.Warn("Failed to look up thread for tweet %s: %v", head.ID, err)
+			return nil, nil
+		}
+		for _, tweet := range convertTweets(searchResponse) {
+			// Only keep self-replies; the author answering other people is not part of the thread.
+			if tweet.ID != head.ID && tweet.AuthorID == head.AuthorID && tweet.InReplyToUser == head.AuthorID {
+				thread = append(thread, tweet)
+			}
+		}
+		if searchResponse.Meta == nil || searchResponse.Meta.NextToken == "" {
+			break
+		}
+		params.Set("next_token", searchResponse.Meta.NextToken)
+	}
+
+	sort.Slice(thread, func(i, j int) bool {
+		return lessID(thread[i].ID, thread[j].ID)
+	})
+	if len(thread) > 0 {
+		c.logger.Info("Unrolled thread for tweet %s with %d follow-up tweets", head.ID, len(thread))
+	}
+	return thread, nil
+}
+
+// lessID orders numeric tweet IDs, which grow over time, without parsing them.
+func lessID(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
 }
 
 // RemoveBookmark removes a tweet from bookmarks.
 func (c *APIClient) RemoveBookmark(tweetID string) error {
 	c.logger.Debug("Attempting to remove bookmark for tweet ID: %s", tweetID)
-	url := fmt.Sprintf("%s/2/users/%s/bookmarks/%s", c.client.Host, c.userID, tweetID)
+	if !c.Authorized() {
+		return ErrNotAuthorized
+	}
+	userID, err := c.user()
+	if err != nil {
+		return fmt.Errorf("failed to remove bookmark: %w", err)
+	}
+	path := fmt.Sprintf("/2/users/%s/bookmarks/%s", userID, tweetID)
+	url := c.client.Host + path
 	req, err := http.NewRequest("DELETE", url, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create remove bookmark request: %v", err)
 	}
 
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.token.AccessToken))
+	rejected := c.tokens.Token().AccessToken
+	c.client.Authorizer.Add(req)
 
+	c.recorder().RecordAPICall(endpointName(path))
 	resp, err := c.client.Client.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to send remove bookmark request: %v", err)
+		return fmt.Errorf("failed to send remove bookmark request: %w", apierr.Transient(err))
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == 401 {
+	if resp.StatusCode == http.StatusUnauthorized {
 		c.logger.Warn("Received 401 Unauthorized, attempting to refresh token")
-		if err := c.refreshToken(); err != nil {
-			return fmt.Errorf("failed to refresh token during bookmark removal: %v", err)
+		if err := c.refresh(rejected); err != nil {
+			return fmt.Errorf("failed to refresh token during bookmark removal: %w", err)
 		}
 
 		c.logger.Info("Retrying to remove bookmark after token refresh")
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.token.AccessToken))
+		c.client.Authorizer.Add(req)
+		c.recorder().RecordAPICall(endpointName(path))
 		resp, err = c.client.Client.Do(req)
 		if err != nil {
-			return fmt.Errorf("failed to send remove bookmark request on retry: %v", err)
+			return fmt.Errorf("failed to send remove bookmark request on retry: %w", apierr.Transient(err))
 		}
 		defer resp.Body.Close()
 	}
 
-	if resp.StatusCode == 200 || resp.StatusCode == 204 {
+	if err := c.checkRateLimit(path, resp); err != nil {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNoContent {
 		c.logger.Debug("Successfully removed bookmark for tweet %s", tweetID)
 		return nil
 	}
-	if resp.StatusCode == 404 {
+	if resp.StatusCode == http.StatusNotFound {
 		c.logger.Debug("Bookmark for tweet %s was not found", tweetID)
 		return nil
 	}
-	if resp.StatusCode == 403 {
-		return fmt.Errorf("insufficient permissions for bookmark removal - re-authorization required")
+	if resp.StatusCode == http.StatusForbidden {
+		return &MissingScopeError{Scope: ScopeBookmarkWrite}
 	}
 
-	return fmt.Errorf("failed to remove bookmark (HTTP %d)", resp.StatusCode)
+	return apierr.FromStatus(resp.StatusCode, fmt.Errorf("failed to remove bookmark (HTTP %d)", resp.StatusCode))
 }
 
 // CleanupProcessedBookmarks removes all bookmarks that have already been processed.
 func (c *APIClient) CleanupProcessedBookmarks(storage storage.Storage) error {
 	c.logger.Info("Starting cleanup of processed bookmarks")
-	tweets, err := c.GetBookmarks()
+	// Walk the full list: processed bookmarks are exactly what we are looking for here.
+	tweets, _, err := c.GetBookmarks(nil, TimelineCursor{})
 	if err != nil {
 		return fmt.Errorf("failed to get bookmarks for cleanup: %v", err)
 	}
@@ -315,6 +685,10 @@ func (c *APIClient) CleanupProcessedBookmarks(storage storage.Storage) error {
 	for _, tweet := range tweets {
 		if storage.IsProcessed(tweet.ID) {
 			if err := c.RemoveBookmark(tweet.ID); err != nil {
+				var scopeErr *MissingScopeError
+				if errors.As(err, &scopeErr) {
+					return fmt.Errorf("cleanup stopped after removing %d bookmarks: %w", removed, err)
+				}
 				c.logger.Warn("Failed to remove processed bookmark %s: %v", tweet.ID, err)
 				failed++
 				continue
@@ -329,31 +703,3 @@ func (c *APIClient) CleanupProcessedBookmarks(storage storage.Storage) error {
 	}
 	return nil
 }
-
-// setupCallbackHandler sets up the OAuth callback endpoint.
-func setupCallbackHandler(mux *http.ServeMux, codeChan chan string, stateChan chan string, errorChan chan error, logger *logger.Logger) {
-	mux.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
-		query := r.URL.Query()
-		code := query.Get("code")
-		state := query.Get("state")
-		errorParam := query.Get("error")
-
-		if errorParam != "" {
-			errorMsg := fmt.Sprintf("OAuth Error: %s", errorParam)
-			http.Error(w, errorMsg, http.StatusBadRequest)
-			errorChan <- fmt.Errorf(errorMsg)
-			return
-		}
-
-		if code == "" {
-			http.Error(w, "Authorization code not found", http.StatusBadRequest)
-			errorChan <- fmt.Errorf("authorization code not found")
-			return
-		}
-
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("Authorization Successful! You can close this window."))
-		codeChan <- code
-		stateChan <- state
-	})
-}
@@ -1,13 +1,26 @@
 package twitter
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
+	"github.com/korjavin/tw2dynalist/internal/apierr"
+	"github.com/korjavin/tw2dynalist/internal/auth"
 	"github.com/korjavin/tw2dynalist/internal/config"
 	"github.com/korjavin/tw2dynalist/internal/logger"
+	"github.com/korjavin/tw2dynalist/internal/source"
+	"github.com/korjavin/tw2dynalist/internal/storage"
 	"golang.org/x/oauth2"
 
 	twitterv2 "github.com/g8rswimmer/go-twitter/v2"
@@ -18,42 +31,1112 @@ type mockAuthorizer struct{}
 
 func (m *mockAuthorizer) Add(req *http.Request) {}
 
+// mockStorage is a mock implementation of the Storage interface.
+type mockStorage struct {
+	processedTweets map[string]bool
+	cursors         map[string]string
+}
+
+func (m *mockStorage) MarkProcessed(tweetID string) {
+	m.processedTweets[tweetID] = true
+}
+
+func (m *mockStorage) IsProcessed(tweetID string) bool {
+	return m.processedTweets[tweetID]
+}
+
+func (m *mockStorage) SetNode(tweetID string, node storage.Node) {}
+
+func (m *mockStorage) GetNode(tweetID string) (storage.Node, bool) {
+	return storage.Node{}, false
+}
+
+func (m *mockStorage) MarkGone(tweetID, reason string) {}
+
+func (m *mockStorage) IsGone(tweetID string) bool {
+	return false
+}
+
+func (m *mockStorage) GetCursor(key string) string {
+	return m.cursors[key]
+}
+
+func (m *mockStorage) SetCursor(key, value string) {
+	m.cursors[key] = value
+}
+
+func (m *mockStorage) Save() error {
+	return nil
+}
+
+func newMockStorage() *mockStorage {
+	return &mockStorage{
+		processedTweets: make(map[string]bool),
+		cursors:         make(map[string]string),
+	}
+}
+
+func newTestTokens() *auth.TokenManager {
+	return auth.NewTokenManager(&oauth2.Config{}, &oauth2.Token{AccessToken: "test_access_token"}, auth.NewTokenStore("", "", nil), "", logger.New("DEBUG"))
+}
+
+func TestAPIClient_GetBookmarks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/2/users/test_user_id/bookmarks" {
+			t.Errorf("Expected to request '/2/users/test_user_id/bookmarks', got '%s'", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintln(w, `{"data":[{"id":"123","text":"test tweet"}],"includes":{"users":[{"id":"456","username":"testuser"}]}}`)
+	}))
+	defer server.Close()
+
+	log := logger.New("DEBUG")
+	cfg := &config.Config{}
+	client := &APIClient{
+		client: &twitterv2.Client{
+			Authorizer: &mockAuthorizer{},
+			Client:     server.Client(),
+			Host:       server.URL,
+		},
+		userID: "test_user_id",
+		logger: log,
+		config: cfg,
+		tokens: newTestTokens(),
+	}
+
+	tweets, _, err := client.GetBookmarks(nil, TimelineCursor{})
+	if err != nil {
+		t.Fatalf("GetBookmarks() returned an error: %v", err)
+	}
+
+	if len(tweets) != 1 {
+		t.Fatalf("Expected 1 tweet, got %d", len(tweets))
+	}
+	if tweets[0].ID != "123" {
+		t.Errorf("Expected tweet ID '123', got '%s'", tweets[0].ID)
+	}
+	if tweets[0].Text != "test tweet" {
+		t.Errorf("Expected tweet text 'test tweet', got '%s'", tweets[0].Text)
+	}
+}
+
+func TestAPIClient_GetBookmarks_GoneTweets(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintln(w, `{"data":[{"id":"123","text":"test tweet","referenced_tweets":[{"type":"quoted","id":"999"}]}],"errors":[`+
+			`{"resource_id":"111","resource_type":"tweet","parameter":"id","title":"Not Found Error","type":"https://api.twitter.com/2/problems/resource-not-found"},`+
+			`{"value":"222","resource_type":"tweet","parameter":"id","title":"Authorization Error","type":"https://api.twitter.com/2/problems/not-authorized-for-resource"},`+
+			`{"resource_id":"999","resource_type":"tweet","parameter":"referenced_tweets.id","title":"Not Found Error","type":"https://api.twitter.com/2/problems/resource-not-found"}]}`)
+	}))
+	defer server.Close()
+
+	client := &APIClient{
+		client: &twitterv2.Client{
+			Authorizer: &mockAuthorizer{},
+			Client:     server.Client(),
+			Host:       server.URL,
+		},
+		userID: "test_user_id",
+		logger: logger.New("DEBUG"),
+		config: &config.Config{},
+		tokens: newTestTokens(),
+	}
+
+	storage := newMockStorage()
+	tweets, cursor, err := client.GetBookmarks(storage, TimelineCursor{})
+	if err != nil {
+		t.Fatalf("GetBookmarks() returned an error: %v", err)
+	}
+	gone := make(map[string]string)
+	for _, tweet := range tweets {
+		if tweet.Gone != "" {
+			gone[tweet.ID] = tweet.Gone
+		}
+	}
+	if len(tweets) != 3 || len(gone) != 2 {
+		t.Fatalf("Expected tweet '123' and two gone tweets, got %+v", tweets)
+	}
+	if gone["111"] != GoneDeleted || gone["222"] != GoneProtected {
+		t.Errorf("Expected '111' deleted and '222' protected, got %v", gone)
+	}
+
+	// The fingerprint of the first page only covers the tweets X returns, so the page looks
+	// unchanged on the next run, but the gone tweets are still reported.
+	tweets, _, err = client.GetBookmarks(storage, cursor)
+	if err != nil {
+		t.Fatalf("GetBookmarks() returned an error: %v", err)
+	}
+	if len(tweets) != 2 || tweets[0].Gone == "" || tweets[1].Gone == "" {
+		t.Errorf("Expected only the two gone tweets from the unchanged page, got %+v", tweets)
+	}
+}
+
+func TestAPIClient_GetBookmarks_Pagination(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Query().Get("pagination_token") {
+		case "":
+			fmt.Fprintln(w, `{"data":[{"id":"3","text":"newest"}],"meta":{"result_count":1,"next_token":"page2"}}`)
+		case "page2":
+			fmt.Fprintln(w, `{"data":[{"id":"2","text":"older"}],"meta":{"result_count":1,"next_token":"page3"}}`)
+		case "page3":
+			fmt.Fprintln(w, `{"data":[{"id":"1","text":"oldest"}],"meta":{"result_count":1}}`)
+		default:
+			t.Errorf("Unexpected pagination token '%s'", r.URL.Query().Get("pagination_token"))
+		}
+	}))
+	defer server.Close()
+
+	client := &APIClient{
+		client: &twitterv2.Client{
+			Authorizer: &mockAuthorizer{},
+			Client:     server.Client(),
+			Host:       server.URL,
+		},
+		userID: "test_user_id",
+		logger: logger.New("DEBUG"),
+		config: &config.Config{},
+		tokens: newTestTokens(),
+	}
+
+	tweets, _, err := client.GetBookmarks(newMockStorage(), TimelineCursor{})
+	if err != nil {
+		t.Fatalf("GetBookmarks() returned an error: %v", err)
+	}
+	if len(tweets) != 3 || requests != 3 {
+		t.Fatalf("Expected 3 tweets from 3 requests, got %d tweets from %d requests", len(tweets), requests)
+	}
+
+	// A fully processed page should stop pagination early.
+	requests = 0
+	storage := newMockStorage()
+	storage.MarkProcessed("2")
+	tweets, _, err = client.GetBookmarks(storage, TimelineCursor{})
+	if err != nil {
+		t.Fatalf("GetBookmarks() returned an error: %v", err)
+	}
+	if len(tweets) != 2 || requests != 2 {
+		t.Errorf("Expected 2 tweets from 2 requests, got %d tweets from %d requests", len(tweets), requests)
+	}
+}
+
+func TestAPIClient_GetBookmarks_Cursor(t *testing.T) {
+	var requests int
+	firstPage := `{"data":[{"id":"3","text":"newest"},{"id":"2","text":"older"}],"meta":{"result_count":2,"next_token":"page2"}}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Query().Get("pagination_token") {
+		case "":
+			fmt.Fprintln(w, firstPage)
+		case "page2":
+			fmt.Fprintln(w, `{"data":[{"id":"1","text":"oldest"}],"meta":{"result_count":1}}`)
+		default:
+			t.Errorf("Unexpected pagination token '%s'", r.URL.Query().Get("pagination_token"))
+		}
+	}))
+	defer server.Close()
+
+	client := &APIClient{
+		client: &twitterv2.Client{
+			Authorizer: &mockAuthorizer{},
+			Client:     server.Client(),
+			Host:       server.URL,
+		},
+		userID: "test_user_id",
+		logger: logger.New("DEBUG"),
+		config: &config.Config{},
+		tokens: newTestTokens(),
+	}
+
+	tweets, cursor, err := client.GetBookmarks(newMockStorage(), TimelineCursor{})
+	if err != nil {
+		t.Fatalf("GetBookmarks() returned an error: %v", err)
+	}
+	if len(tweets) != 3 || cursor.NewestID != "3" || cursor.Fingerprint == "" {
+		t.Fatalf("Expected 3 tweets and a cursor at '3', got %d tweets and %+v", len(tweets), cursor)
+	}
+
+	// An unchanged first page returns nothing after a single request.
+	requests = 0
+	tweets, unchanged, err := client.GetBookmarks(newMockStorage(), cursor)
+	if err != nil {
+		t.Fatalf("GetBookmarks() returned an error: %v", err)
+	}
+	if len(tweets) != 0 || requests != 1 || unchanged != cursor {
+		t.Errorf("Expected no tweets from 1 request and the same cursor, got %d tweets from %d requests and %+v", len(tweets), requests, unchanged)
+	}
+
+	// A new bookmark is returned without paging past the newest one of the last run, even
+	// though the tweets after it are not marked as processed.
+	requests = 0
+	firstPage = `{"data":[{"id":"4","text":"new"},{"id":"3","text":"newest"},{"id":"2","text":"older"}],"meta":{"result_count":3,"next_token":"page2"}}`
+	tweets, next, err := client.GetBookmarks(newMockStorage(), cursor)
+	if err != nil {
+		t.Fatalf("GetBookmarks() returned an error: %v", err)
+	}
+	if len(tweets) != 1 || tweets[0].ID != "4" || requests != 1 {
+		t.Errorf("Expected only tweet '4' from 1 request, got %+v from %d requests", tweets, requests)
+	}
+	if next.NewestID != "4" || next.Fingerprint == cursor.Fingerprint {
+		t.Errorf("Expected a new cursor at '4', got %+v", next)
+	}
+}
+
+// mockRecorder records the rate limits reported by the client.
+type mockRecorder struct {
+	rateLimits     map[string]RateLimit
+	tokenRefreshes int
+}
+
+func (m *mockRecorder) RecordBookmarkPage(page, tweets, unseen int) {}
+
+func (m *mockRecorder) RecordRateLimit(endpoint string, rl RateLimit) {
+	m.rateLimits[endpoint] = rl
+}
+
+func (m *mockRecorder) RecordAPICall(endpoint string) {}
+
+func (m *mockRecorder) RecordTokenExpiry(expiresAt time.Time) {}
+
+func (m *mockRecorder) RecordTokenRefresh(expiresAt time.Time) {
+	m.tokenRefreshes++
+}
+
+func TestAPIClient_GetBookmarks_RateLimit(t *testing.T) {
+	reset := time.Now().Add(10 * time.Minute).Unix()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("x-rate-limit-limit", "180")
+		w.Header().Set("x-rate-limit-reset", strconv.FormatInt(reset, 10))
+		if r.URL.Query().Get("pagination_token") == "" {
+			w.Header().Set("x-rate-limit-remaining", "1")
+			fmt.Fprintln(w, `{"data":[{"id":"2","text":"newest"}],"meta":{"result_count":1,"next_token":"page2"}}`)
+			return
+		}
+		w.Header().Set("x-rate-limit-remaining", "0")
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprintln(w, `{"title":"Too Many Requests","detail":"Too Many Requests","type":"about:blank","status":429}`)
+	}))
+	defer server.Close()
+
+	recorder := &mockRecorder{rateLimits: make(map[string]RateLimit)}
+	client := &APIClient{
+		client: &twitterv2.Client{
+			Authorizer: &mockAuthorizer{},
+			Client:     server.Client(),
+			Host:       server.URL,
+		},
+		userID:  "12345",
+		logger:  logger.New("DEBUG"),
+		config:  &config.Config{},
+		tokens:  newTestTokens(),
+		metrics: recorder,
+	}
+
+	tweets, _, err := client.GetBookmarks(newMockStorage(), TimelineCursor{})
+	var rateLimitErr *RateLimitError
+	if !errors.As(err, &rateLimitErr) {
+		t.Fatalf("Expected a RateLimitError, got %v", err)
+	}
+	if rateLimitErr.Reset.Unix() != reset {
+		t.Errorf("Expected reset at %d, got %d", reset, rateLimitErr.Reset.Unix())
+	}
+	if len(tweets) != 1 || tweets[0].ID != "2" {
+		t.Errorf("Expected the tweets of the first page, got %v", tweets)
+	}
+
+	rl, ok := recorder.rateLimits["/2/users/:id/bookmarks"]
+	if !ok {
+		t.Fatalf("Expected the bookmarks rate limit to be recorded, got %v", recorder.rateLimits)
+	}
+	if rl.Limit != 180 || rl.Remaining != 0 {
+		t.Errorf("Expected 0/180 remaining, got %d/%d", rl.Remaining, rl.Limit)
+	}
+}
+
+func TestAPIClient_GetBookmarks_RefreshesRejectedToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/oauth2/token":
+			if r.FormValue("refresh_token") != "old_refresh_token" {
+				t.Errorf("Unexpected refresh token '%s'", r.FormValue("refresh_token"))
+			}
+			fmt.Fprintln(w, `{"access_token":"new_access_token","refresh_token":"new_refresh_token","token_type":"bearer","expires_in":7200}`)
+		case r.Header.Get("Authorization") != "Bearer new_access_token":
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprintln(w, `{"title":"Unauthorized","type":"about:blank","status":401,"detail":"Unauthorized"}`)
+		default:
+			fmt.Fprintln(w, `{"data":[{"id":"1","text":"tweet"}],"meta":{"result_count":1}}`)
+		}
+	}))
+	defer server.Close()
+
+	log := logger.New("DEBUG")
+	oauth2Config := &oauth2.Config{Endpoint: oauth2.Endpoint{TokenURL: server.URL + "/oauth2/token"}}
+	tokens := auth.NewTokenManager(oauth2Config, &oauth2.Token{AccessToken: "old_access_token", RefreshToken: "old_refresh_token"}, auth.NewTokenStore(filepath.Join(t.TempDir(), "token.json"), "", log), "test_user_id", log)
+	recorder := &mockRecorder{rateLimits: make(map[string]RateLimit)}
+	tokens.OnRefresh(func(token *oauth2.Token) { recorder.RecordTokenRefresh(token.Expiry) })
+
+	client := &APIClient{
+		client: &twitterv2.Client{
+			Authorizer: tokens,
+			Client:     server.Client(),
+			Host:       server.URL,
+		},
+		userID:  "test_user_id",
+		logger:  log,
+		config:  &config.Config{},
+		tokens:  tokens,
+		metrics: recorder,
+	}
+
+	tweets, _, err := client.GetBookmarks(newMockStorage(), TimelineCursor{})
+	if err != nil {
+		t.Fatalf("GetBookmarks() returned an error: %v", err)
+	}
+	if len(tweets) != 1 {
+		t.Errorf("Expected 1 tweet, got %d", len(tweets))
+	}
+	if recorder.tokenRefreshes != 1 {
+		t.Errorf("Expected 1 token refresh, got %d", recorder.tokenRefreshes)
+	}
+
+	// A refresh for a token that has already been replaced is a no-op.
+	if err := tokens.Refresh("old_access_token"); err != nil {
+		t.Errorf("Refresh() of a replaced token returned an error: %v", err)
+	}
+	if recorder.tokenRefreshes != 1 {
+		t.Errorf("Expected the replaced token not to be refreshed again, got %d refreshes", recorder.tokenRefreshes)
+	}
+}
+
+func TestAPIClient_GetBookmarks_RefreshRefused(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/oauth2/token" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, `{"error":"invalid_request","error_description":"Value passed for the token was invalid."}`)
+			return
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprintln(w, `{"title":"Unauthorized","type":"about:blank","status":401,"detail":"Unauthorized"}`)
+	}))
+	defer server.Close()
+
+	log := logger.New("DEBUG")
+	oauth2Config := &oauth2.Config{Endpoint: oauth2.Endpoint{TokenURL: server.URL + "/oauth2/token"}}
+	tokenFile := filepath.Join(t.TempDir(), "token.json")
+	if err := os.WriteFile(tokenFile, []byte(`{}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tokens := auth.NewTokenManager(oauth2Config, &oauth2.Token{AccessToken: "old_access_token", RefreshToken: "old_refresh_token"}, auth.NewTokenStore(tokenFile, "", log), "test_user_id", log)
+	client := &APIClient{
+		client: &twitterv2.Client{
+			Authorizer: tokens,
+			Client:     server.Client(),
+			Host:       server.URL,
+		},
+		userID: "test_user_id",
+		logger: log,
+		config: &config.Config{TokenFilePath: tokenFile},
+		tokens: tokens,
+	}
+
+	_, _, err := client.GetBookmarks(newMockStorage(), TimelineCursor{})
+	if !errors.Is(err, ErrNotAuthorized) || !errors.Is(err, apierr.ErrInvalidToken) {
+		t.Fatalf("Expected ErrNotAuthorized for an invalid token, got %v", err)
+	}
+	if client.Authorized() {
+		t.Error("Expected the client to need authorization after a refused refresh")
+	}
+	if _, err := os.Stat(tokenFile); err != nil {
+		t.Errorf("Expected the token file to be kept, got %v", err)
+	}
+}
+
+func TestAPIClient_GetBookmarks_RefreshUnavailable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/oauth2/token" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, `{"error":"server_error"}`)
+			return
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprintln(w, `{"title":"Unauthorized","type":"about:blank","status":401,"detail":"Unauthorized"}`)
+	}))
+	defer server.Close()
+
+	log := logger.New("DEBUG")
+	oauth2Config := &oauth2.Config{Endpoint: oauth2.Endpoint{TokenURL: server.URL + "/oauth2/token"}}
+	tokens := auth.NewTokenManager(oauth2Config, &oauth2.Token{AccessToken: "old_access_token", RefreshToken: "old_refresh_token"}, auth.NewTokenStore(filepath.Join(t.TempDir(), "token.json"), "", log), "test_user_id", log)
+	client := &APIClient{
+		client: &twitterv2.Client{
+			Authorizer: tokens,
+			Client:     server.Client(),
+			Host:       server.URL,
+		},
+		userID: "test_user_id",
+		logger: log,
+		config: &config.Config{},
+		tokens: tokens,
+	}
+
+	_, _, err := client.GetBookmarks(newMockStorage(), TimelineCursor{})
+	if !errors.Is(err, apierr.ErrTransient) || errors.Is(err, apierr.ErrInvalidToken) {
+		t.Fatalf("Expected ErrTransient for an unavailable token endpoint, got %v", err)
+	}
+	if !client.Authorized() || tokens.Token().RefreshToken != "old_refresh_token" {
+		t.Error("Expected the token to be kept when the token endpoint fails")
+	}
+}
+
+func TestNewClient_UserLookupFails(t *testing.T) {
+	var lookups int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/2/users/me":
+			lookups++
+			if lookups == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				fmt.Fprintln(w, `{"title":"Service Unavailable","type":"about:blank","status":503,"detail":"Service Unavailable"}`)
+				return
+			}
+			fmt.Fprintln(w, `{"data":{"id":"42","name":"Test","username":"test_user"}}`)
+		case "/2/users/42/bookmarks":
+			fmt.Fprintln(w, `{"data":[{"id":"1","text":"tweet"}],"meta":{"result_count":1}}`)
+		default:
+			t.Errorf("Unexpected request to %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	log := logger.New("DEBUG")
+	tokenFile := filepath.Join(t.TempDir(), "token.json")
+	token := &oauth2.Token{AccessToken: "access_token", RefreshToken: "refresh_token", Expiry: time.Now().Add(time.Hour)}
+	if err := auth.NewTokenStore(tokenFile, "", log).SaveToken(token, ""); err != nil {
+		t.Fatalf("SaveToken() returned an error: %v", err)
+	}
+	cfg := &config.Config{TokenFilePath: tokenFile, TwitterAPIURL: server.URL}
+
+	client, err := NewClient(cfg, log, NewRouter(http.NewServeMux()))
+	if err != nil {
+		t.Fatalf("NewClient() returned an error: %v", err)
+	}
+	defer client.Close()
+	if !client.Authorized() {
+		t.Fatal("Expected the token to be kept when the user lookup fails")
+	}
+
+	tweets, _, err := client.GetBookmarks(newMockStorage(), TimelineCursor{})
+	if err != nil {
+		t.Fatalf("GetBookmarks() returned an error: %v", err)
+	}
+	if len(tweets) != 1 || lookups != 2 {
+		t.Errorf("Expected the user to be looked up again before fetching, got %d tweets and %d lookups", len(tweets), lookups)
+	}
+}
+
+func TestAPIClient_Authorize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/oauth2/token":
+			if r.FormValue("code") != "test_code" || r.FormValue("code_verifier") == "" {
+				t.Errorf("Unexpected token request: %v", r.Form)
+			}
+			fmt.Fprintln(w, `{"access_token":"new_access_token","refresh_token":"new_refresh_token","token_type":"bearer","expires_in":7200,"scope":"tweet.read users.read bookmark.read offline.access"}`)
+		case "/2/users/me":
+			fmt.Fprintln(w, `{"data":{"id":"42","name":"Test","username":"test_user"}}`)
+		default:
+			t.Errorf("Unexpected request to %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	log := logger.New("DEBUG")
+	oauth2Config := &oauth2.Config{
+		ClientID:    "client_id",
+		RedirectURL: "http://localhost/callback",
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  server.URL + "/oauth2/authorize",
+			TokenURL: server.URL + "/oauth2/token",
+		},
+	}
+	tokenFile := filepath.Join(t.TempDir(), "token.json")
+	tokens := auth.NewTokenManager(oauth2Config, nil, auth.NewTokenStore(tokenFile, "", log), "", log)
+	client := &APIClient{
+		client: &twitterv2.Client{
+			Authorizer: tokens,
+			Client:     server.Client(),
+			Host:       server.URL,
+		},
+		logger:       log,
+		config:       &config.Config{TwitterUsername: "@test_user", TokenFilePath: tokenFile},
+		oauth2Config: oauth2Config,
+		tokens:       tokens,
+	}
+	authorized := make(chan struct{})
+	client.OnAuthorized(func() { close(authorized) })
+
+	if client.Authorized() {
+		t.Fatal("Expected a client without token to need authorization")
+	}
+
+	// A callback without a flow in progress is rejected.
+	rec := httptest.NewRecorder()
+	client.handleCallback(rec, httptest.NewRequest(http.MethodGet, "/callback?code=test_code", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without authorization in progress, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	client.handleAuthorize(rec, httptest.NewRequest(http.MethodGet, AuthorizePath, nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("Expected a redirect, got status %d", rec.Code)
+	}
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil || location.Query().Get("code_challenge") == "" {
+		t.Fatalf("Expected a redirect with a PKCE challenge, got %q", rec.Header().Get("Location"))
+	}
+
+	state := location.Query().Get("state")
+	if state == "" || state == "state" {
+		t.Fatalf("Expected a random state, got %q", state)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != stateCookie || cookies[0].Value != state || !cookies[0].HttpOnly {
+		t.Fatalf("Expected an HttpOnly cookie holding the state, got %+v", cookies)
+	}
+	callback := func(query string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/callback?"+query, nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		client.handleCallback(rec, req)
+		return rec
+	}
+
+	// A callback with a different state is rejected.
+	if rec := callback("code=test_code&state=forged", cookies[0]); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a forged state, got %d", rec.Code)
+	}
+
+	// A callback from a browser that did not start the flow is rejected, without ending it.
+	if rec := callback("code=test_code&state="+state, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without the state cookie, got %d", rec.Code)
+	}
+	if !client.hasAuthFlow(state) {
+		t.Fatal("Expected the flow to survive a callback from another browser")
+	}
+
+	rec = callback("code=test_code&state="+state, cookies[0])
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if cleared := rec.Result().Cookies(); len(cleared) != 1 || cleared[0].MaxAge >= 0 {
+		t.Errorf("Expected the state cookie to be cleared, got %+v", cleared)
+	}
+
+	// Repeating the callback does not run the flow again.
+	rec = callback("code=test_code&state="+state, cookies[0])
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a repeated callback, got %d", rec.Code)
+	}
+
+	select {
+	case <-authorized:
+	case <-time.After(time.Second):
+		t.Fatal("OnAuthorized was not called")
+	}
+	if userID, _ := client.user(); !client.Authorized() || userID != "42" {
+		t.Errorf("Expected the client to be authorized as user 42, got %v/%s", client.Authorized(), userID)
+	}
+	token, userID, err := auth.NewTokenStore(tokenFile, "", log).LoadToken()
+	if err != nil || userID != "42" {
+		t.Errorf("Expected the token to be saved for user 42, got %s (%v)", userID, err)
+	}
+	if scopes := auth.GrantedScopes(token); len(scopes) != 4 || scopes[2] != ScopeBookmarkRead {
+		t.Errorf("Expected the granted scopes to be saved with the token, got %v", scopes)
+	}
+	if scopes := client.GrantedScopes(); len(scopes) != 4 {
+		t.Errorf("Expected 4 granted scopes, got %v", scopes)
+	}
+}
+
+func TestRouter_RoutesAuthorizationByAccount(t *testing.T) {
+	log := logger.New("DEBUG")
+	newClient := func(account string) *APIClient {
+		return &APIClient{
+			logger: log,
+			config: &config.Config{AccountName: account},
+			oauth2Config: &oauth2.Config{
+				ClientID: "client_id",
+				Endpoint: oauth2.Endpoint{AuthURL: "https://x.example/oauth2/authorize"},
+			},
+		}
+	}
+	alice, bob := newClient("alice"), newClient("bob")
+	router := NewRouter(http.NewServeMux())
+	router.add(alice)
+	router.add(bob)
+
+	rec := httptest.NewRecorder()
+	router.handleAuthorize(rec, httptest.NewRequest(http.MethodGet, AuthorizeLink("carol"), nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown account, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	router.handleAuthorize(rec, httptest.NewRequest(http.MethodGet, AuthorizeLink("bob"), nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("Expected a redirect, got status %d", rec.Code)
+	}
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("Failed to parse redirect: %v", err)
+	}
+	state := location.Query().Get("state")
+	if !bob.hasAuthFlow(state) || alice.hasAuthFlow(state) {
+		t.Errorf("Expected the authorization to be started for bob only")
+	}
+}
+
+func TestAPIClient_AuthFlowsAreBounded(t *testing.T) {
+	client := &APIClient{
+		logger: logger.New("DEBUG"),
+		config: &config.Config{AccountName: "alice"},
+		oauth2Config: &oauth2.Config{
+			ClientID: "client_id",
+			Endpoint: oauth2.Endpoint{AuthURL: "https://x.example/oauth2/authorize"},
+		},
+	}
+
+	var states []string
+	start := time.Now()
+	for i := 0; i < maxAuthFlows+2; i++ {
+		rec := httptest.NewRecorder()
+		client.handleAuthorize(rec, httptest.NewRequest(http.MethodGet, AuthorizeLink("alice"), nil))
+		location, err := url.Parse(rec.Header().Get("Location"))
+		if err != nil {
+			t.Fatalf("Failed to parse redirect: %v", err)
+		}
+		states = append(states, location.Query().Get("state"))
+		if cookies := rec.Result().Cookies(); len(cookies) != 1 || cookies[0].Name != stateCookie+"_alice" {
+			t.Errorf("Expected a state cookie named after the account, got %+v", cookies)
+		}
+		// Keep the start times apart, so that the oldest flow is well defined.
+		client.authMu.Lock()
+		flow := client.authFlows[states[i]]
+		flow.started = start.Add(time.Duration(i) * time.Second)
+		client.authFlows[states[i]] = flow
+		client.authMu.Unlock()
+	}
+
+	if len(client.authFlows) != maxAuthFlows {
+		t.Errorf("Expected %d pending flows, got %d", maxAuthFlows, len(client.authFlows))
+	}
+	if client.hasAuthFlow(states[0]) || client.hasAuthFlow(states[1]) {
+		t.Error("Expected the oldest flows to be dropped")
+	}
+	if !client.hasAuthFlow(states[len(states)-1]) {
+		t.Error("Expected the newest flow to be kept")
+	}
+}
+
+func TestParseAuthorizationResponse(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{input: "bare_code", want: "bare_code"},
+		{input: "http://localhost:8080/callback?state=abc&code=url_code", want: "url_code"},
+		{input: "state=abc&code=query_code", want: "query_code"},
+		{input: "http://localhost:8080/callback?state=other&code=url_code", wantErr: true},
+		{input: "http://localhost:8080/callback?state=abc&error=access_denied", wantErr: true},
+		{input: "http://localhost:8080/callback?state=abc", wantErr: true},
+		{input: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseAuthorizationResponse(tt.input, "abc")
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseAuthorizationResponse(%q) = %q, %v; want %q, error %v", tt.input, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestAPIClient_AuthorizeHeadless(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/oauth2/token":
+			if r.FormValue("code") != "pasted_code" {
+				t.Errorf("Unexpected code '%s'", r.FormValue("code"))
+			}
+			fmt.Fprintln(w, `{"access_token":"new_access_token","refresh_token":"new_refresh_token","token_type":"bearer","expires_in":7200}`)
+		case "/2/users/me":
+			fmt.Fprintln(w, `{"data":{"id":"42","name":"Test","username":"test_user"}}`)
+		default:
+			t.Errorf("Unexpected request to %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	log := logger.New("DEBUG")
+	tokenFile := filepath.Join(t.TempDir(), "token.json")
+	cfg := &config.Config{
+		TwitterUsername:    "test_user",
+		TokenFilePath:      tokenFile,
+		TwitterRedirectURL: "http://localhost:8080/callback",
+		TwitterAPIURL:      server.URL,
+		TwitterAuthURL:     server.URL + "/oauth2/authorize",
+		TwitterTokenURL:    server.URL + "/oauth2/token",
+	}
+	client := newAPIClient(cfg, log, auth.NewTokenStore(tokenFile, "", log), nil, "")
+
+	var out strings.Builder
+	if err := client.authorizeHeadless(strings.NewReader("pasted_code\n"), &out); err != nil {
+		t.Fatalf("authorizeHeadless() returned an error: %v", err)
+	}
+	if !strings.Contains(out.String(), "code_challenge=") {
+		t.Errorf("Expected the authorization URL to be printed, got %q", out.String())
+	}
+
+	token, userID, err := auth.NewTokenStore(tokenFile, "", log).LoadToken()
+	if err != nil || token.AccessToken != "new_access_token" || userID != "42" {
+		t.Errorf("Expected the token to be saved for user 42, got %+v for %s (%v)", token, userID, err)
+	}
+}
+
+func TestAPIClient_GetBookmarks_Thread(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/2/users/test_user_id/bookmarks":
+			fmt.Fprintln(w, `{"data":[{"id":"100","text":"1/ thread","author_id":"42","conversation_id":"100","public_metrics":{"reply_count":3}}],"includes":{"users":[{"id":"42","username":"author"}]}}`)
+		case "/2/tweets/search/recent":
+			if q := r.URL.Query().Get("query"); q != "conversation_id:100 from:author" {
+				t.Errorf("Unexpected search query '%s'", q)
+			}
+			fmt.Fprintln(w, `{"data":[`+
+				`{"id":"103","text":"3/ end","author_id":"42","conversation_id":"100","in_reply_to_user_id":"42"},`+
+				`{"id":"102","text":"thanks!","author_id":"42","conversation_id":"100","in_reply_to_user_id":"7"},`+
+				`{"id":"101","text":"2/ middle","author_id":"42","conversation_id":"100","in_reply_to_user_id":"42"}`+
+				`],"includes":{"users":[{"id":"42","username":"author"}]}}`)
+		default:
+			t.Errorf("Unexpected request to '%s'", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	client := &APIClient{
+		client: &twitterv2.Client{
+			Authorizer: &mockAuthorizer{},
+			Client:     server.Client(),
+			Host:       server.URL,
+		},
+		userID: "test_user_id",
+		logger: logger.New("DEBUG"),
+		config: &config.Config{UnrollThreads: true},
+		tokens: newTestTokens(),
+	}
+
+	tweets, _, err := client.GetBookmarks(newMockStorage(), TimelineCursor{})
+	if err != nil {
+		t.Fatalf("GetBookmarks() returned an error: %v", err)
+	}
+	if len(tweets) != 1 {
+		t.Fatalf("Expected 1 tweet, got %d", len(tweets))
+	}
+	thread := tweets[0].Thread
+	if len(thread) != 2 {
+		t.Fatalf("Expected 2 follow-up tweets, got %d", len(thread))
+	}
+	if thread[0].ID != "101" || thread[1].ID != "103" {
+		t.Errorf("Expected thread [101 103], got [%s %s]", thread[0].ID, thread[1].ID)
+	}
+}
+
+func TestAPIClient_GetBookmarks_NoRepliesNotSearched(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/2/users/test_user_id/bookmarks":
+			fmt.Fprintln(w, `{"data":[`+
+				`{"id":"200","text":"single tweet","author_id":"42","conversation_id":"200","public_metrics":{"reply_count":0}},`+
+				`{"id":"100","text":"another one","author_id":"42","conversation_id":"100","public_metrics":{"reply_count":0}}`+
+				`],"includes":{"users":[{"id":"42","username":"author"}]}}`)
+		default:
+			t.Errorf("Unexpected request to '%s'", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	client := &APIClient{
+		client: &twitterv2.Client{
+			Authorizer: &mockAuthorizer{},
+			Client:     server.Client(),
+			Host:       server.URL,
+		},
+		userID: "test_user_id",
+		logger: logger.New("DEBUG"),
+		config: &config.Config{UnrollThreads: true},
+		tokens: newTestTokens(),
+	}
+
+	tweets, _, err := client.GetBookmarks(newMockStorage(), TimelineCursor{})
+	if err != nil {
+		t.Fatalf("GetBookmarks() returned an error: %v", err)
+	}
+	if len(tweets) != 2 {
+		t.Fatalf("Expected 2 tweets, got %d", len(tweets))
+	}
+	for _, tweet := range tweets {
+		if tweet.Thread != nil {
+			t.Errorf("Expected no thread for tweet %s, got %+v", tweet.ID, tweet.Thread)
+		}
+	}
+}
+
+// decodeTweetsResponse parses a JSON fixture as returned by a tweet-returning endpoint.
+func decodeTweetsResponse(t *testing.T, body string) *tweetsResponse {
+	t.Helper()
+	raw := &tweetsResponse{}
+	if err := json.Unmarshal([]byte(body), raw); err != nil {
+		t.Fatalf("Failed to decode fixture: %v", err)
+	}
+	return raw
+}
+
+func TestAPIClient_GetBookmarks_ThreadRateLimit(t *testing.T) {
+	oldDate := time.Now().Add(-30 * 24 * time.Hour).UTC().Format(time.RFC3339)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/2/users/test_user_id/bookmarks":
+			fmt.Fprintln(w, `{"data":[`+
+				`{"id":"300","text":"1/ old thread","author_id":"42","conversation_id":"300","created_at":"`+oldDate+`"},`+
+				`{"id":"200","text":"1/ thread","author_id":"42","conversation_id":"200"},`+
+				`{"id":"100","text":"older"}`+
+				`],"includes":{"users":[{"id":"42","username":"author"}]}}`)
+		case "/2/tweets/search/recent":
+			if q := r.URL.Query().Get("query"); q != "conversation_id:200 from:author" {
+				t.Errorf("Expected only the recent thread to be searched, got query '%s'", q)
+			}
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprintln(w, `{"title":"Too Many Requests","detail":"Too Many Requests","type":"about:blank","status":429}`)
+		default:
+			t.Errorf("Unexpected request to '%s'", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	client := &APIClient{
+		client: &twitterv2.Client{
+			Authorizer: &mockAuthorizer{},
+			Client:     server.Client(),
+			Host:       server.URL,
+		},
+		userID: "test_user_id",
+		logger: logger.New("DEBUG"),
+		config: &config.Config{UnrollThreads: true},
+		tokens: newTestTokens(),
+	}
 
-// mockStorage is a mock implementation of the Storage interface.
-type mockStorage struct {
-	processedTweets map[string]bool
+	tweets, _, err := client.GetBookmarks(newMockStorage(), TimelineCursor{})
+	var rateLimitErr *RateLimitError
+	if !errors.As(err, &rateLimitErr) {
+		t.Fatalf("Expected a RateLimitError, got %v", err)
+	}
+	if len(tweets) != 1 || tweets[0].ID != "300" {
+		t.Errorf("Expected only the tweet before the failed thread lookup, got %+v", tweets)
+	}
 }
 
-func (m *mockStorage) MarkProcessed(tweetID string) {
-	m.processedTweets[tweetID] = true
+func TestConvertTweets_ReferencedTweets(t *testing.T) {
+	raw := decodeTweetsResponse(t, `{
+		"data": [{"id": "1", "text": "look at this", "author_id": "10",
+			"referenced_tweets": [{"type": "quoted", "id": "2"}, {"type": "replied_to", "id": "3"}]}],
+		"includes": {
+			"users": [{"id": "10", "username": "quoter"}, {"id": "20", "username": "original"}],
+			"tweets": [{"id": "2", "text": "original thought", "author_id": "20"}]
+		}
+	}`)
+
+	tweets := convertTweets(raw)
+	if len(tweets) != 1 {
+		t.Fatalf("Expected 1 tweet, got %d", len(tweets))
+	}
+	// The replied_to tweet is missing from includes and should be skipped.
+	if len(tweets[0].Referenced) != 1 {
+		t.Fatalf("Expected 1 referenced tweet, got %d", len(tweets[0].Referenced))
+	}
+	ref := tweets[0].Referenced[0]
+	if ref.Type != "quoted" || ref.Tweet.Text != "original thought" {
+		t.Errorf("Unexpected referenced tweet: %+v", ref)
+	}
+	if ref.Tweet.URL != "https://twitter.com/original/status/2" {
+		t.Errorf("Expected referenced URL 'https://twitter.com/original/status/2', got '%s'", ref.Tweet.URL)
+	}
 }
 
-func (m *mockStorage) IsProcessed(tweetID string) bool {
-	return m.processedTweets[tweetID]
+func TestConvertTweets_Media(t *testing.T) {
+	raw := decodeTweetsResponse(t, `{
+		"data": [{"id": "1", "text": "chart", "attachments": {"media_keys": ["3_1", "7_2"]}}],
+		"includes": {
+			"media": [
+				{"media_key": "3_1", "type": "photo", "url": "https://pbs.twimg.com/media/chart.png", "alt_text": "a chart"},
+				{"media_key": "7_2", "type": "video", "preview_image_url": "https://pbs.twimg.com/preview.jpg"}
+			]
+		}
+	}`)
+
+	tweets := convertTweets(raw)
+	if len(tweets) != 1 || len(tweets[0].Media) != 2 {
+		t.Fatalf("Expected 1 tweet with 2 media, got %+v", tweets)
+	}
+	photo, video := tweets[0].Media[0], tweets[0].Media[1]
+	if photo.URL != "https://pbs.twimg.com/media/chart.png" || photo.AltText != "a chart" {
+		t.Errorf("Unexpected photo: %+v", photo)
+	}
+	if video.URL != "https://pbs.twimg.com/preview.jpg" {
+		t.Errorf("Expected video to fall back to preview image URL, got '%s'", video.URL)
+	}
 }
 
-func (m *mockStorage) Save() error {
-	return nil
+func TestConvertTweets_NoteTweet(t *testing.T) {
+	raw := decodeTweetsResponse(t, `{
+		"data": [{
+			"id": "1",
+			"text": "A very long post that gets cut off… https://t.co/short",
+			"entities": {"urls": [{"url": "https://t.co/short", "expanded_url": "https://twitter.com/i/web/status/1"}]},
+			"note_tweet": {
+				"text": "A very long post that gets cut off in the text field but not here, see https://t.co/link",
+				"entities": {"urls": [{"url": "https://t.co/link", "expanded_url": "https://example.com/post"}]}
+			}
+		}]
+	}`)
+
+	tweets := convertTweets(raw)
+	if len(tweets) != 1 {
+		t.Fatalf("Expected 1 tweet, got %d", len(tweets))
+	}
+	if tweets[0].Text != "A very long post that gets cut off in the text field but not here, see https://example.com/post" {
+		t.Errorf("Expected the full note tweet text, got '%s'", tweets[0].Text)
+	}
+	if len(tweets[0].URLs) != 1 || tweets[0].URLs[0] != "https://example.com/post" {
+		t.Errorf("Expected URLs from the note tweet entities, got %v", tweets[0].URLs)
+	}
 }
 
-func newMockStorage() *mockStorage {
-	return &mockStorage{
-		processedTweets: make(map[string]bool),
+func TestExpandURLs(t *testing.T) {
+	entities := &twitterv2.EntitiesObj{
+		URLs: []twitterv2.EntityURLObj{
+			{URL: "https://t.co/abc", ExpandedURL: "https://go.dev/blog/go1.23", DisplayURL: "go.dev/blog/go1.23"},
+			{URL: "https://t.co/quote", ExpandedURL: "https://twitter.com/golang/status/99"},
+			{URL: "https://t.co/pic", ExpandedURL: "https://twitter.com/me/status/1/photo/1", MediaKey: "3_1"},
+		},
+	}
+
+	text, urls := expandURLs("Read https://t.co/abc and https://t.co/quote https://t.co/pic", entities)
+	if text != "Read https://go.dev/blog/go1.23 and https://twitter.com/golang/status/99" {
+		t.Errorf("Unexpected expanded text: '%s'", text)
+	}
+	if len(urls) != 1 || urls[0] != "https://go.dev/blog/go1.23" {
+		t.Errorf("Expected only the outbound go.dev URL, got %v", urls)
 	}
 }
 
-func TestAPIClient_GetBookmarks(t *testing.T) {
+func TestAPIClient_GetFolderBookmarks(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path != "/2/users/test_user_id/bookmarks" {
-			t.Errorf("Expected to request '/2/users/test_user_id/bookmarks', got '%s'", r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/2/users/test_user_id/bookmarks/folders":
+			fmt.Fprintln(w, `{"data":[{"id":"f1","name":"papers"}]}`)
+		case "/2/users/test_user_id/bookmarks/folders/f1":
+			if r.URL.Query().Get("pagination_token") == "page2" {
+				fmt.Fprintln(w, `{"data":[{"id":"3"}]}`)
+				return
+			}
+			fmt.Fprintln(w, `{"data":[{"id":"1"},{"id":"2"}],"meta":{"next_token":"page2"}}`)
+		case "/2/tweets":
+			if ids := r.URL.Query().Get("ids"); ids != "1,3" {
+				t.Errorf("Expected the unprocessed ids '1,3', got '%s'", ids)
+			}
+			fmt.Fprintln(w, `{"data":[{"id":"1","text":"first"},{"id":"3","text":"third"}]}`)
+		default:
+			t.Errorf("Unexpected request to '%s'", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	client := &APIClient{
+		client: &twitterv2.Client{
+			Authorizer: &mockAuthorizer{},
+			Client:     server.Client(),
+			Host:       server.URL,
+		},
+		userID: "test_user_id",
+		logger: logger.New("DEBUG"),
+		config: &config.Config{},
+		tokens: newTestTokens(),
+	}
+
+	folders, err := client.GetBookmarkFolders()
+	if err != nil {
+		t.Fatalf("GetBookmarkFolders() returned an error: %v", err)
+	}
+	if len(folders) != 1 || folders[0].Name != "papers" {
+		t.Fatalf("Expected folder 'papers', got %+v", folders)
+	}
+
+	store := newMockStorage()
+	store.MarkProcessed("2")
+	tweets, err := client.GetFolderBookmarks(folders[0], store)
+	if err != nil {
+		t.Fatalf("GetFolderBookmarks() returned an error: %v", err)
+	}
+	if len(tweets) != 2 {
+		t.Fatalf("Expected 2 tweets, got %d", len(tweets))
+	}
+	for _, tweet := range tweets {
+		if tweet.Folder != "papers" {
+			t.Errorf("Expected tweet %s to be in folder 'papers', got '%s'", tweet.ID, tweet.Folder)
 		}
+	}
+}
+
+func TestBookmarkSource_Folders(t *testing.T) {
+	foldersFail := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
-		fmt.Fprintln(w, `{"data":[{"id":"123","text":"test tweet"}],"includes":{"users":[{"id":"456","username":"testuser"}]}}`)
+		switch r.URL.Path {
+		case "/2/users/test_user_id/bookmarks":
+			fmt.Fprintln(w, `{"data":[{"id":"4","text":"inbox"},{"id":"1","text":"paper"}]}`)
+		case "/2/users/test_user_id/bookmarks/folders":
+			if foldersFail {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				fmt.Fprintln(w, `{"title":"Service Unavailable","type":"about:blank","status":503,"detail":"Service Unavailable"}`)
+				return
+			}
+			fmt.Fprintln(w, `{"data":[{"id":"f1","name":"papers"}]}`)
+		case "/2/users/test_user_id/bookmarks/folders/f1":
+			fmt.Fprintln(w, `{"data":[{"id":"1"}]}`)
+		case "/2/tweets":
+			fmt.Fprintln(w, `{"data":[{"id":"1","text":"paper"}]}`)
+		default:
+			t.Errorf("Unexpected request to '%s'", r.URL.Path)
+		}
 	}))
 	defer server.Close()
 
-	log := logger.New("DEBUG")
-	cfg := &config.Config{}
 	client := &APIClient{
 		client: &twitterv2.Client{
 			Authorizer: &mockAuthorizer{},
@@ -61,27 +1144,197 @@ func TestAPIClient_GetBookmarks(t *testing.T) {
 			Host:       server.URL,
 		},
 		userID: "test_user_id",
-		logger: log,
-		config: cfg,
-		token: &oauth2.Token{
-			AccessToken: "test_access_token",
+		logger: logger.New("DEBUG"),
+		config: &config.Config{},
+		tokens: newTestTokens(),
+	}
+	src := NewBookmarkSource(client, []string{"papers"}, func() bool { return false }, logger.New("DEBUG"))
+
+	items, err := src.Fetch(newMockStorage())
+	if err != nil {
+		t.Fatalf("Fetch() returned an error: %v", err)
+	}
+	if len(items) != 2 || items[0].ID != "1" || items[0].Folder != "papers" || items[1].ID != "4" || items[1].Folder != "" {
+		t.Fatalf("Expected tweet 1 from the folder and tweet 4 from the main list only, got %+v", items)
+	}
+
+	foldersFail = true
+	items, err = src.Fetch(newMockStorage())
+	if err == nil || len(items) != 0 {
+		t.Errorf("Expected no items and an error when the folders cannot be read, got %+v, %v", items, err)
+	}
+}
+
+func TestAPIClient_GetLikedTweets(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/2/users/test_user_id/liked_tweets" {
+			t.Errorf("Expected to request '/2/users/test_user_id/liked_tweets', got '%s'", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintln(w, `{"data":[{"id":"789","text":"liked tweet"}],"meta":{"result_count":1}}`)
+	}))
+	defer server.Close()
+
+	client := &APIClient{
+		client: &twitterv2.Client{
+			Authorizer: &mockAuthorizer{},
+			Client:     server.Client(),
+			Host:       server.URL,
 		},
-		oauth2Config: &oauth2.Config{},
+		userID: "test_user_id",
+		logger: logger.New("DEBUG"),
+		config: &config.Config{},
+		tokens: newTestTokens(),
 	}
 
-	tweets, err := client.GetBookmarks()
+	tweets, _, err := client.GetLikedTweets(newMockStorage(), TimelineCursor{})
 	if err != nil {
-		t.Fatalf("GetBookmarks() returned an error: %v", err)
+		t.Fatalf("GetLikedTweets() returned an error: %v", err)
+	}
+	if len(tweets) != 1 || tweets[0].ID != "789" {
+		t.Fatalf("Expected liked tweet '789', got %+v", tweets)
 	}
+}
 
-	if len(tweets) != 1 {
-		t.Fatalf("Expected 1 tweet, got %d", len(tweets))
+func TestAPIClient_GetLikedTweets_MissingScope(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintln(w, `{"title":"Forbidden","type":"about:blank","status":403,"detail":"Forbidden"}`)
+	}))
+	defer server.Close()
+
+	client := &APIClient{
+		client: &twitterv2.Client{
+			Authorizer: &mockAuthorizer{},
+			Client:     server.Client(),
+			Host:       server.URL,
+		},
+		userID: "test_user_id",
+		logger: logger.New("DEBUG"),
+		config: &config.Config{},
+		tokens: newTestTokens(),
 	}
-	if tweets[0].ID != "123" {
-		t.Errorf("Expected tweet ID '123', got '%s'", tweets[0].ID)
+
+	_, _, err := client.GetLikedTweets(newMockStorage(), TimelineCursor{})
+	var scopeErr *MissingScopeError
+	if !errors.As(err, &scopeErr) || scopeErr.Scope != ScopeLikeRead {
+		t.Fatalf("Expected a missing %s scope for a 403, got %v", ScopeLikeRead, err)
 	}
-	if tweets[0].Text != "test tweet" {
-		t.Errorf("Expected tweet text 'test tweet', got '%s'", tweets[0].Text)
+	if !errors.Is(err, apierr.ErrForbidden) {
+		t.Errorf("Expected the error to be ErrForbidden, got %v", err)
+	}
+}
+
+func TestAPIClient_GetListTweets(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/2/lists/list1/tweets" {
+			t.Errorf("Expected to request '/2/lists/list1/tweets', got '%s'", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintln(w, `{"data":[{"id":"30","text":"newest"},{"id":"20","text":"known"},{"id":"10","text":"old"}],"meta":{"next_token":"more"}}`)
+	}))
+	defer server.Close()
+
+	client := &APIClient{
+		client: &twitterv2.Client{
+			Authorizer: &mockAuthorizer{},
+			Client:     server.Client(),
+			Host:       server.URL,
+		},
+		userID: "test_user_id",
+		logger: logger.New("DEBUG"),
+		config: &config.Config{},
+		tokens: newTestTokens(),
+	}
+
+	tweets, err := client.GetListTweets("list1", "20")
+	if err != nil {
+		t.Fatalf("GetListTweets() returned an error: %v", err)
+	}
+	if len(tweets) != 1 || tweets[0].ID != "30" {
+		t.Fatalf("Expected only tweet '30' after since ID '20', got %+v", tweets)
+	}
+	if newest := NewestID(tweets, "20"); newest != "30" {
+		t.Errorf("Expected newest ID '30', got '%s'", newest)
+	}
+}
+
+func TestAPIClient_GetListTweets_Errors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/2/lists/missing/tweets" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintln(w, `{"title":"Not Found Error","type":"about:blank","status":404,"detail":"Could not find list"}`)
+			return
+		}
+		if r.URL.Path == "/2/lists/forbidden/tweets" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintln(w, `{"title":"Forbidden","type":"about:blank","status":403,"detail":"Forbidden"}`)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, `{"title":"Service Unavailable","type":"about:blank","status":503,"detail":"Service Unavailable"}`)
+	}))
+	defer server.Close()
+
+	client := &APIClient{
+		client: &twitterv2.Client{
+			Authorizer: &mockAuthorizer{},
+			Client:     server.Client(),
+			Host:       server.URL,
+		},
+		userID: "test_user_id",
+		logger: logger.New("DEBUG"),
+		config: &config.Config{},
+		tokens: newTestTokens(),
+	}
+
+	if _, err := client.GetListTweets("missing", ""); !errors.Is(err, apierr.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a missing list, got %v", err)
+	}
+	var scopeErr *MissingScopeError
+	if _, err := client.GetListTweets("forbidden", ""); !errors.As(err, &scopeErr) || scopeErr.Scope != ScopeListRead {
+		t.Errorf("Expected a missing %s scope for a 403, got %v", ScopeListRead, err)
+	}
+	_, err := client.GetListTweets("list1", "")
+	var errorResponse *twitterv2.ErrorResponse
+	if !errors.Is(err, apierr.ErrTransient) || !errors.As(err, &errorResponse) {
+		t.Errorf("Expected ErrTransient wrapping the error response for a 503, got %v", err)
+	}
+}
+
+func TestAPIClient_SearchRecentTweets(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/2/tweets/search/recent" {
+			t.Errorf("Expected to request '/2/tweets/search/recent', got '%s'", r.URL.Path)
+		}
+		if q := r.URL.Query(); q.Get("query") != "from:golang release" || q.Get("since_id") != "100" {
+			t.Errorf("Unexpected query parameters: %v", q)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintln(w, `{"data":[{"id":"101","text":"Go 1.24 is released"}],"meta":{"result_count":1}}`)
+	}))
+	defer server.Close()
+
+	client := &APIClient{
+		client: &twitterv2.Client{
+			Authorizer: &mockAuthorizer{},
+			Client:     server.Client(),
+			Host:       server.URL,
+		},
+		userID: "test_user_id",
+		logger: logger.New("DEBUG"),
+		config: &config.Config{},
+		tokens: newTestTokens(),
+	}
+
+	tweets, err := client.SearchRecentTweets("from:golang release", "100")
+	if err != nil {
+		t.Fatalf("SearchRecentTweets() returned an error: %v", err)
+	}
+	if len(tweets) != 1 || tweets[0].ID != "101" {
+		t.Fatalf("Expected tweet '101', got %+v", tweets)
 	}
 }
 
@@ -109,10 +1362,7 @@ func TestAPIClient_RemoveBookmark(t *testing.T) {
 		userID: "test_user_id",
 		logger: log,
 		config: cfg,
-		token: &oauth2.Token{
-			AccessToken: "test_access_token",
-		},
-		oauth2Config: &oauth2.Config{},
+		tokens: newTestTokens(),
 	}
 
 	err := client.RemoveBookmark("123")
@@ -121,6 +1371,77 @@ func TestAPIClient_RemoveBookmark(t *testing.T) {
 	}
 }
 
+func TestAPIClient_Logout(t *testing.T) {
+	revoked := make(map[string]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/2/oauth2/revoke" || r.Method != http.MethodPost {
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if r.FormValue("client_id") != "client_id" {
+			t.Errorf("Expected client_id 'client_id', got '%s'", r.FormValue("client_id"))
+		}
+		revoked[r.FormValue("token_type_hint")] = r.FormValue("token")
+		fmt.Fprintln(w, `{"revoked":true}`)
+	}))
+	defer server.Close()
+
+	log := logger.New("DEBUG")
+	tokenFile := filepath.Join(t.TempDir(), "token.json")
+	store := auth.NewTokenStore(tokenFile, "", log)
+	token := &oauth2.Token{AccessToken: "test_access_token", RefreshToken: "test_refresh_token"}
+	if err := store.SaveToken(token, "42"); err != nil {
+		t.Fatalf("Failed to save token: %v", err)
+	}
+	oauth2Config := &oauth2.Config{ClientID: "client_id"}
+	client := &APIClient{
+		userID:       "42",
+		logger:       log,
+		config:       &config.Config{TokenFilePath: tokenFile},
+		oauth2Config: oauth2Config,
+		revokeURL:    server.URL + "/2/oauth2/revoke",
+		tokens:       auth.NewTokenManager(oauth2Config, token, store, "42", log),
+	}
+
+	if err := client.Logout(); err != nil {
+		t.Fatalf("Logout failed: %v", err)
+	}
+	if revoked["refresh_token"] != "test_refresh_token" || revoked["access_token"] != "test_access_token" {
+		t.Errorf("Expected both tokens to be revoked, got %v", revoked)
+	}
+	if client.Authorized() {
+		t.Error("Expected the client to be unauthorized after logout")
+	}
+	if _, err := os.Stat(tokenFile); !os.IsNotExist(err) {
+		t.Errorf("Expected the token file to be deleted, got %v", err)
+	}
+}
+
+func TestAPIClient_RemoveBookmark_MissingScope(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintln(w, `{"title":"Forbidden","type":"about:blank","status":403,"detail":"Forbidden"}`)
+	}))
+	defer server.Close()
+
+	client := &APIClient{
+		client: &twitterv2.Client{
+			Authorizer: &mockAuthorizer{},
+			Client:     server.Client(),
+			Host:       server.URL,
+		},
+		userID: "test_user_id",
+		logger: logger.New("DEBUG"),
+		config: &config.Config{},
+		tokens: newTestTokens(),
+	}
+
+	err := client.RemoveBookmark("123")
+	var scopeErr *MissingScopeError
+	if !errors.As(err, &scopeErr) || scopeErr.Scope != ScopeBookmarkWrite {
+		t.Fatalf("Expected a MissingScopeError for %s, got %v", ScopeBookmarkWrite, err)
+	}
+}
+
 func TestAPIClient_CleanupProcessedBookmarks(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == "GET" {
@@ -147,10 +1468,7 @@ func TestAPIClient_CleanupProcessedBookmarks(t *testing.T) {
 		userID: "test_user_id",
 		logger: log,
 		config: cfg,
-		token: &oauth2.Token{
-			AccessToken: "test_access_token",
-		},
-		oauth2Config: &oauth2.Config{},
+		tokens: newTestTokens(),
 	}
 
 	err := client.CleanupProcessedBookmarks(storage)
@@ -158,3 +1476,107 @@ func TestAPIClient_CleanupProcessedBookmarks(t *testing.T) {
 		t.Fatalf("CleanupProcessedBookmarks() returned an error: %v", err)
 	}
 }
+
+func TestTweet_Item(t *testing.T) {
+	tweet := Tweet{
+		ID:             "1",
+		Text:           "head",
+		URL:            "https://twitter.com/alice/status/1",
+		AuthorUsername: "alice",
+		CreatedAt:      time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		Folder:         "papers",
+		Media:          []Media{{Type: "photo", URL: "https://pbs.twimg.com/a.jpg", AltText: "a cat"}},
+		Referenced:     []ReferencedTweet{{Type: "quoted", Tweet: Tweet{ID: "9", Text: "quoted", AuthorUsername: "bob"}}},
+		Thread:         []Tweet{{ID: "2", Text: "reply", URL: "https://twitter.com/alice/status/2"}},
+	}
+
+	item := tweet.Item(BookmarksSourceName)
+	if item.ID != "1" || item.Source != BookmarksSourceName || item.Author != "alice" || item.Folder != "papers" || !item.CreatedAt.Equal(tweet.CreatedAt) {
+		t.Errorf("Unexpected item: %+v", item)
+	}
+	if len(item.Attachments) != 1 || item.Attachments[0] != (source.Attachment{Type: "photo", URL: "https://pbs.twimg.com/a.jpg", Description: "a cat"}) {
+		t.Errorf("Unexpected attachments: %+v", item.Attachments)
+	}
+	if len(item.References) != 1 || item.References[0].Label != "Quoted" || item.References[0].Item.Author != "bob" {
+		t.Errorf("Unexpected references: %+v", item.References)
+	}
+	if len(item.Children) != 1 || item.Children[0].ID != "2" {
+		t.Errorf("Unexpected children: %+v", item.Children)
+	}
+}
+
+func TestTimelineSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("since_id"); got != "10" {
+			t.Errorf("Expected since_id '10', got '%s'", got)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintln(w, `{"data":[{"id":"30","text":"Go generics"},{"id":"20","text":"lunch"}]}`)
+	}))
+	defer server.Close()
+
+	client := &APIClient{
+		client: &twitterv2.Client{
+			Authorizer: &mockAuthorizer{},
+			Client:     server.Client(),
+			Host:       server.URL,
+		},
+		userID: "test_user_id",
+		logger: logger.New("DEBUG"),
+		config: &config.Config{},
+		tokens: newTestTokens(),
+	}
+	src := NewTimelineSource(client, config.SourceConfig{Type: config.SourceTypeSearch, Target: "golang", Keywords: []string{"generics"}}, logger.New("DEBUG"))
+	store := newMockStorage()
+	store.SetCursor(sinceIDCursor, "10")
+
+	items, err := src.Fetch(store)
+	if err != nil {
+		t.Fatalf("Fetch() returned an error: %v", err)
+	}
+	if len(items) != 1 || items[0].ID != "30" || items[0].Source != "search:golang" {
+		t.Fatalf("Expected only item '30' of source 'search:golang', got %+v", items)
+	}
+	if got := store.GetCursor(sinceIDCursor); got != "10" {
+		t.Errorf("Expected the cursor to stay at '10' until Commit, got '%s'", got)
+	}
+	src.Commit(store)
+	if got := store.GetCursor(sinceIDCursor); got != "30" {
+		t.Errorf("Expected the cursor to move to '30', got '%s'", got)
+	}
+}
+
+func TestReadArchiveLikes(t *testing.T) {
+	archive := fstest.MapFS{
+		"data/like.js": {Data: []byte(`window.YTD.like.part0 = [
+  {"like": {"tweetId": "3", "fullText": "newest like", "expandedUrl": "https://twitter.com/i/web/status/3"}},
+  {"like": {"tweetId": "2", "fullText": "older like"}}
+]`)},
+		"data/like-part1.js": {Data: []byte(`window.YTD.like.part1 = [{"like": {"tweetId": "1", "fullText": "oldest like"}}]`)},
+		"data/tweets.js":     {Data: []byte(`window.YTD.tweets.part0 = []`)},
+	}
+
+	tweets, err := ReadArchiveLikes(archive)
+	if err != nil {
+		t.Fatalf("ReadArchiveLikes() returned an error: %v", err)
+	}
+	if len(tweets) != 3 || tweets[0].ID != "3" || tweets[1].ID != "2" || tweets[2].ID != "1" {
+		t.Fatalf("Expected likes 3, 2 and 1 in order, got %+v", tweets)
+	}
+	if tweets[1].Text != "older like" || tweets[1].URL != "https://twitter.com/i/web/status/2" {
+		t.Errorf("Unexpected tweet without expanded URL: %+v", tweets[1])
+	}
+
+	if _, err := ReadArchiveLikes(fstest.MapFS{}); err == nil {
+		t.Error("Expected an error for an archive without likes")
+	}
+	if _, err := ReadArchiveLikes(fstest.MapFS{"data/like.js": {Data: []byte(`not an archive`)}}); err == nil {
+		t.Error("Expected an error for a malformed like.js")
+	}
+	for _, body := range []string{"window.YTD.like.part0 = ", `window.YTD.like.part0 = [{"like":{"tweetId":"1"`} {
+		_, err := ReadArchiveLikes(fstest.MapFS{"data/like-part1.js": {Data: []byte(body)}})
+		if err == nil || !strings.Contains(err.Error(), "data/like-part1.js") {
+			t.Errorf("Expected an error naming the file for %q, got %v", body, err)
+		}
+	}
+}
@@ -1,13 +1,55 @@
 package main
 
 import (
+	"flag"
 	"log"
+	"os"
+	"time"
 
 	"github.com/korjavin/tw2dynalist/internal/app"
 )
 
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
+
+	if len(os.Args) > 1 {
+		// The commands take the name of the account to work on when ACCOUNTS is configured.
+		var account string
+		if len(os.Args) > 2 {
+			account = os.Args[2]
+		}
+		switch os.Args[1] {
+		case "auth":
+			if err := app.Authorize(account); err != nil {
+				log.Fatalf("Authorization failed: %v", err)
+			}
+			return
+		case "logout":
+			if err := app.Logout(account); err != nil {
+				log.Fatalf("Logout failed: %v", err)
+			}
+			return
+		case "import-archive":
+			flags := flag.NewFlagSet("import-archive", flag.ExitOnError)
+			delay := flags.Duration("delay", time.Second, "time to wait between saving two tweets")
+			flags.Usage = func() {
+				log.Printf("Usage: %s import-archive [-delay duration] <archive> [account]", os.Args[0])
+				flags.PrintDefaults()
+			}
+			flags.Parse(os.Args[2:])
+			if flags.NArg() < 1 {
+				flags.Usage()
+				os.Exit(2)
+			}
+			if err := app.ImportArchive(flags.Arg(0), flags.Arg(1), *delay); err != nil {
+				log.Fatalf("Archive import failed: %v", err)
+			}
+			return
+		default:
+			log.Fatalf("Unknown command %q, expected \"auth\", \"logout\" or \"import-archive\"", os.Args[1])
+		}
+	}
+
 	log.Println("Starting Twitter to Dynalist bot")
 
 	application, err := app.New()